@@ -2,6 +2,9 @@ package app
 
 import (
 	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
 
 	"github.com/joystream/onchain-git-poc/x/gitService"
 	"github.com/tendermint/tendermint/libs/log"
@@ -9,9 +12,11 @@ import (
 
 	"github.com/cosmos/cosmos-sdk/codec"
 	"github.com/cosmos/cosmos-sdk/x/auth"
+	"github.com/cosmos/cosmos-sdk/x/params"
 	"github.com/cosmos/cosmos-sdk/x/stake"
 
 	bam "github.com/cosmos/cosmos-sdk/baseapp"
+	"github.com/cosmos/cosmos-sdk/store"
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	abci "github.com/tendermint/tendermint/abci/types"
 	cmn "github.com/tendermint/tendermint/libs/common"
@@ -20,38 +25,63 @@ import (
 
 const (
 	appName = "gitService"
+	// maxHistoricalStores is the number of multistores loaded for queries at earlier heights
+	// that are kept for further queries at the same heights. A fetch or listing at a height
+	// issues all of its queries at that height.
+	maxHistoricalStores = 2
 )
 
 // GitServiceApp is the main type
 type GitServiceApp struct {
 	*bam.BaseApp
 	cdc *codec.Codec
+	db  dbm.DB
 
 	keyMain          *sdk.KVStoreKey
 	keyAccount       *sdk.KVStoreKey
 	keyGit           *sdk.KVStoreKey
 	keyFeeCollection *sdk.KVStoreKey
+	keyParams        *sdk.KVStoreKey
+	tkeyParams       *sdk.TransientStoreKey
 
 	accountKeeper       auth.AccountKeeper
 	feeCollectionKeeper auth.FeeCollectionKeeper
+	paramsKeeper        params.Keeper
 	gitServiceKeeper    gitService.Keeper
+
+	// historicalStores caches the multistores loaded for queries at earlier heights by height,
+	// since loading one reads the root of every version of each store.
+	// historicalHeights holds their heights, the least recently loaded first.
+	historicalStores  map[int64]sdk.CommitMultiStore
+	historicalHeights []int64
+	historicalMtx     sync.Mutex
 }
 
-// NewGitServiceApp instantiates GitServiceApp
-func NewGitServiceApp(logger log.Logger, db dbm.DB) *GitServiceApp {
+// NewGitServiceApp instantiates GitServiceApp, loading the latest version of the state unless
+// loadLatest is false, in which case LoadHeight must be called
+func NewGitServiceApp(logger log.Logger, db dbm.DB, loadLatest bool,
+	baseAppOptions ...func(*bam.BaseApp)) *GitServiceApp {
 	cdc := MakeCodec()
-	bApp := bam.NewBaseApp(appName, logger, db, auth.DefaultTxDecoder(cdc))
+	bApp := bam.NewBaseApp(appName, logger, db, auth.DefaultTxDecoder(cdc), baseAppOptions...)
 
 	var app = &GitServiceApp{
 		BaseApp: bApp,
 		cdc:     cdc,
+		db:      db,
 
 		keyMain:          sdk.NewKVStoreKey("main"),
 		keyAccount:       sdk.NewKVStoreKey("acc"),
-		keyGit:           sdk.NewKVStoreKey("git"),
+		keyGit:           sdk.NewKVStoreKey(gitService.StoreKey),
 		keyFeeCollection: sdk.NewKVStoreKey("fee_collection"),
+		keyParams:        sdk.NewKVStoreKey("params"),
+		tkeyParams:       sdk.NewTransientStoreKey("transient_params"),
+
+		historicalStores: make(map[int64]sdk.CommitMultiStore),
 	}
 
+	// The ParamsKeeper handles parameter storage for the application
+	app.paramsKeeper = params.NewKeeper(app.cdc, app.keyParams, app.tkeyParams)
+
 	// The AccountKeeper handles address -> account lookups
 	app.accountKeeper = auth.NewAccountKeeper(
 		app.cdc,
@@ -60,7 +90,9 @@ func NewGitServiceApp(logger log.Logger, db dbm.DB) *GitServiceApp {
 	)
 
 	app.feeCollectionKeeper = auth.NewFeeCollectionKeeper(cdc, app.keyFeeCollection)
-	app.gitServiceKeeper = gitService.NewKeeper(app.keyGit, app.cdc)
+	app.gitServiceKeeper = gitService.NewKeeper(app.keyGit, app.accountKeeper,
+		app.feeCollectionKeeper, app.paramsKeeper.Subspace(gitService.DefaultParamspace),
+		app.cdc, gitService.DefaultCodespace)
 	app.SetAnteHandler(auth.NewAnteHandler(app.accountKeeper, app.feeCollectionKeeper))
 
 	app.Router().
@@ -72,24 +104,135 @@ func NewGitServiceApp(logger log.Logger, db dbm.DB) *GitServiceApp {
 	// The initChainer handles translating the genesis.json file into initial state for the network
 	app.SetInitChainer(app.initChainer)
 
-	app.MountStores(
+	app.MountStores(app.kvStoreKeys()...)
+	app.MountStoresTransient(app.tkeyParams)
+
+	if loadLatest {
+		err := app.LoadLatestVersion(app.keyMain)
+		if err != nil {
+			cmn.Exit(err.Error())
+		}
+	}
+
+	return app
+}
+
+// kvStoreKeys returns the keys of the stores persisted by the application
+func (app *GitServiceApp) kvStoreKeys() []*sdk.KVStoreKey {
+	return []*sdk.KVStoreKey{
 		app.keyMain,
 		app.keyAccount,
 		app.keyGit,
-	)
+		app.keyFeeCollection,
+		app.keyParams,
+	}
+}
 
-	err := app.LoadLatestVersion(app.keyMain)
+// Query implements the ABCI application interface. The base application serves custom queries
+// from the latest state regardless of the requested height, so custom queries for an earlier
+// height get served from the state at that height.
+func (app *GitServiceApp) Query(req abci.RequestQuery) abci.ResponseQuery {
+	path := strings.Split(strings.TrimPrefix(req.Path, "/"), "/")
+	if req.Height <= 0 || req.Height == app.LastBlockHeight() || len(path) < 2 ||
+		path[0] != "custom" {
+		return app.BaseApp.Query(req)
+	}
+
+	return app.queryAtHeight(path, req)
+}
+
+// queryAtHeight serves a custom query from the state at the requested height, which is only
+// available if the node hasn't pruned it
+func (app *GitServiceApp) queryAtHeight(path []string, req abci.RequestQuery) (
+	res abci.ResponseQuery) {
+	querier := app.QueryRouter().Route(path[1])
+	if querier == nil {
+		return sdk.ErrUnknownRequest(fmt.Sprintf("no custom querier found for route %s",
+			path[1])).QueryResult()
+	}
+
+	cms, err := app.storeAtHeight(req.Height)
 	if err != nil {
-		cmn.Exit(err.Error())
+		return sdk.ErrUnknownRequest(fmt.Sprintf("state at height %d isn't available: %s",
+			req.Height, err)).QueryResult()
 	}
+	// A cached store reads its nodes lazily, so they may get pruned from under it
+	defer func() {
+		if r := recover(); r != nil {
+			app.evictStoreAtHeight(req.Height)
+			res = sdk.ErrUnknownRequest(fmt.Sprintf("state at height %d isn't available: %v",
+				req.Height, r)).QueryResult()
+		}
+	}()
 
-	return app
+	ctx := sdk.NewContext(cms.CacheMultiStore(), abci.Header{Height: req.Height}, true,
+		app.Logger)
+	resBytes, queryErr := querier(ctx, path[2:], req)
+	if queryErr != nil {
+		return abci.ResponseQuery{
+			Code:      uint32(queryErr.Code()),
+			Codespace: string(queryErr.Codespace()),
+			Log:       queryErr.ABCILog(),
+		}
+	}
+
+	return abci.ResponseQuery{
+		Code:   uint32(sdk.CodeOK),
+		Value:  resBytes,
+		Height: req.Height,
+	}
+}
+
+// storeAtHeight gets the multistore at an earlier height, loading it unless it's cached. Only
+// the latest maxHistoricalStores multistores loaded are kept.
+func (app *GitServiceApp) storeAtHeight(height int64) (sdk.CommitMultiStore, error) {
+	app.historicalMtx.Lock()
+	defer app.historicalMtx.Unlock()
+	if cms, ok := app.historicalStores[height]; ok {
+		return cms, nil
+	}
+
+	cms := store.NewCommitMultiStore(app.db)
+	for _, key := range app.kvStoreKeys() {
+		cms.MountStoreWithDB(key, sdk.StoreTypeIAVL, nil)
+	}
+	cms.MountStoreWithDB(app.tkeyParams, sdk.StoreTypeTransient, nil)
+	if err := cms.LoadVersion(height); err != nil {
+		return nil, err
+	}
+
+	if len(app.historicalHeights) == maxHistoricalStores {
+		delete(app.historicalStores, app.historicalHeights[0])
+		app.historicalHeights = app.historicalHeights[1:]
+	}
+	app.historicalStores[height] = cms
+	app.historicalHeights = append(app.historicalHeights, height)
+	return cms, nil
+}
+
+// evictStoreAtHeight drops the cached multistore at a height, if any
+func (app *GitServiceApp) evictStoreAtHeight(height int64) {
+	app.historicalMtx.Lock()
+	defer app.historicalMtx.Unlock()
+	if _, ok := app.historicalStores[height]; !ok {
+		return
+	}
+
+	delete(app.historicalStores, height)
+	for i, h := range app.historicalHeights {
+		if h == height {
+			app.historicalHeights = append(app.historicalHeights[:i],
+				app.historicalHeights[i+1:]...)
+			break
+		}
+	}
 }
 
 // GenesisState represents chain state at the start of the chain. Any initial state
 // (account balances) are stored here.
 type GenesisState struct {
-	Accounts []*auth.BaseAccount `json:"accounts"`
+	Accounts   []*auth.BaseAccount     `json:"accounts"`
+	GitService gitService.GenesisState `json:"git_service"`
 }
 
 func (app *GitServiceApp) initChainer(ctx sdk.Context, req abci.RequestInitChain) abci.ResponseInitChain {
@@ -105,6 +248,13 @@ func (app *GitServiceApp) initChainer(ctx sdk.Context, req abci.RequestInitChain
 		acc.AccountNumber = app.accountKeeper.GetNextAccountNumber(ctx)
 		app.accountKeeper.SetAccount(ctx, acc)
 	}
+	if err := gitService.ValidateGenesis(genesisState.GitService); err != nil {
+		panic(err)
+	}
+	if err := gitService.InitGenesis(ctx, app.gitServiceKeeper,
+		genesisState.GitService); err != nil {
+		panic(err)
+	}
 
 	return abci.ResponseInitChain{}
 }
@@ -127,7 +277,14 @@ func (app *GitServiceApp) ExportAppStateAndValidators() (appState json.RawMessag
 
 	app.accountKeeper.IterateAccounts(ctx, appendAccountsFn)
 
-	genState := GenesisState{Accounts: accounts}
+	gitServiceState, err := gitService.ExportGenesis(ctx, app.gitServiceKeeper)
+	if err != nil {
+		return nil, nil, err
+	}
+	genState := GenesisState{
+		Accounts:   accounts,
+		GitService: gitServiceState,
+	}
 	appState, err = codec.MarshalJSONIndent(app.cdc, genState)
 	if err != nil {
 		return nil, nil, err
@@ -136,6 +293,18 @@ func (app *GitServiceApp) ExportAppStateAndValidators() (appState json.RawMessag
 	return appState, validators, err
 }
 
+// LoadHeight loads the version of the state at a height
+func (app *GitServiceApp) LoadHeight(height int64) error {
+	return app.LoadVersion(height, app.keyMain)
+}
+
+// ExportRepository exports the state of a repository, or nil if it doesn't exist
+func (app *GitServiceApp) ExportRepository(owner string, name string) (
+	*gitService.GenesisRepository, error) {
+	ctx := app.NewContext(true, abci.Header{})
+	return app.gitServiceKeeper.ExportRepository(ctx, owner, name)
+}
+
 // MakeCodec generates the necessary codecs for Amino
 func MakeCodec() *codec.Codec {
 	var cdc = codec.New()
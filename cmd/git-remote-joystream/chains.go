@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+
+	gitServiceCmd "github.com/joystream/onchain-git-poc/x/gitService/client/cli"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultNode = "tcp://localhost:26657"
+
+// chainConfig holds the client settings for talking to a certain chain, as registered in the
+// chain registry
+type chainConfig struct {
+	id        string
+	node      string
+	trustNode bool
+	home      string
+}
+
+// lookupChain resolves a chain ID through the chain registry, which lives in Git config as
+// joystream.<chainID>.node, joystream.<chainID>.trustNode, joystream.<chainID>.key and
+// joystream.<chainID>.home. The default signing key gets resolved by gitservicecli itself.
+func lookupChain(chainID string) (*chainConfig, error) {
+	log.Debug().Msgf("Looking up chain '%s' in registry", chainID)
+	section := fmt.Sprintf("joystream.%s", chainID)
+	node, err := gitServiceCmd.GitConfig(section + ".node")
+	if err != nil {
+		return nil, err
+	}
+	trustNode, err := gitServiceCmd.GitConfig(section+".trustNode", "--bool")
+	if err != nil {
+		return nil, err
+	}
+	key, err := gitServiceCmd.GitConfig(section + ".key")
+	if err != nil {
+		return nil, err
+	}
+	home, err := gitServiceCmd.GitConfig(section+".home", "--path")
+	if err != nil {
+		return nil, err
+	}
+
+	if node == "" && trustNode == "" && key == "" && home == "" {
+		return nil, fmt.Errorf(
+			"Chain '%s' isn't registered, configure it with 'git config --global %s.node <address>'",
+			chainID, section)
+	}
+	if node == "" {
+		node = defaultNode
+	}
+
+	chain := &chainConfig{
+		id:        chainID,
+		node:      node,
+		trustNode: trustNode == "true",
+		home:      home,
+	}
+	log.Debug().Msgf("Resolved chain '%s': node: '%s', trust node: %t", chainID, chain.node,
+		chain.trustNode)
+	return chain, nil
+}
+
+// cliFlags returns the gitservicecli flags for addressing the chain
+func (c *chainConfig) cliFlags() []string {
+	flags := []string{
+		"--chain-id", c.id,
+		"--node", c.node,
+		fmt.Sprintf("--trust-node=%t", c.trustNode),
+	}
+	if c.home != "" {
+		flags = append(flags, "--home", c.home)
+	}
+
+	return flags
+}
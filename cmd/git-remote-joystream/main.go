@@ -2,9 +2,14 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"io"
+	neturl "net/url"
 	"os"
+	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/rs/zerolog"
@@ -14,13 +19,73 @@ import (
 
 var reJoystreamURL = regexp.MustCompile("joystream://(.+)/(.+)/(.+)")
 
-func handlePushBatch(args [][]string, repo repository) error {
+// envCLILogLevel is the environment variable for gitservicecli's log level
+const envCLILogLevel = "GITSERVICECLI_LOG_LEVEL"
+
+// runGitServiceCLI invokes gitservicecli against the repository's chain. If stdin is nil,
+// gitservicecli gets the terminal as stdin, if there is one.
+func runGitServiceCLI(repo repository, opts *options, stdin io.Reader, stdout io.Writer,
+	args ...string) error {
+	args = append(args, repo.chain.cliFlags()...)
+	log.Debug().Msgf("Invoking gitservicecli %v", args)
+	cmd := exec.Command("gitservicecli", args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", envCLILogLevel, opts.logLevel()))
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = stdin
+	// Our stdin is reserved for talking to Git, so let gitservicecli prompt for passphrases on
+	// the terminal, if there is one
+	if stdin == nil {
+		if tty, err := os.Open("/dev/tty"); err == nil {
+			defer tty.Close()
+			cmd.Stdin = tty
+		}
+	}
+	return cmd.Run()
+}
+
+func handlePushBatch(args [][]string, repo repository, opts *options) error {
 	log.Debug().Msgf("Handling push batch for repo %v: %v", repo, args)
-	// TODO: Call gitservicecli tx gitService push-refs [...]
+	if repo.height > 0 {
+		return fmt.Errorf("Can't push to repo %v, which is pinned to height %d", repo,
+			repo.height)
+	}
+	cliArgs := []string{"tx", "gitService", "push-refs", repo.uri(), "--porcelain"}
+	dsts := make([]string, 0, len(args))
+	for _, pushArgs := range args {
+		if len(pushArgs) != 1 {
+			return fmt.Errorf("Bad push request: %v", pushArgs)
+		}
+
+		refSpec := pushArgs[0]
+		cliArgs = append(cliArgs, refSpec)
+		dsts = append(dsts, refSpec[strings.LastIndex(refSpec, ":")+1:])
+	}
+	if repo.remote != "" {
+		cliArgs = append(cliArgs, "--remote", repo.remote)
+	}
+	cliArgs = append(cliArgs, opts.pushFlags()...)
+
+	// In porcelain mode, gitservicecli reports the status of each reference on stdout
+	var report bytes.Buffer
+	err := runGitServiceCLI(repo, opts, nil, &report, cliArgs...)
+	if report.Len() > 0 {
+		os.Stdout.Write(report.Bytes())
+	} else {
+		for _, dst := range dsts {
+			if err != nil {
+				fmt.Printf("error %s %s\n", dst, err)
+			} else {
+				fmt.Printf("ok %s\n", dst)
+			}
+		}
+	}
+	fmt.Printf("\n")
+
 	return nil
 }
 
-func handleList(repo repository, command []string) error {
+func handleList(repo repository, opts *options, command []string) error {
 	log.Debug().Msgf("Listing refs in %v - command: %v", repo, command)
 	if len(command) == 1 && command[0] == "for-push" {
 		log.Debug().Msgf("Treating for-push the same as a regular list")
@@ -28,41 +93,100 @@ func handleList(repo repository, command []string) error {
 		return fmt.Errorf("Bad list request: %v", command)
 	}
 
-	// TODO: Ask gitservicecli to query for refs in repo
-	// TODO: Print refs got from gitservicecli
+	cliArgs := append([]string{"query", "gitService", "list", repo.uri()}, repo.heightFlags()...)
+	if err := runGitServiceCLI(repo, opts, nil, os.Stdout, cliArgs...); err != nil {
+		return err
+	}
 
 	fmt.Printf("\n")
 	return nil
 }
 
 type repository struct {
-	chainID string
-	owner   string
-	name    string
+	chain *chainConfig
+	owner string
+	name  string
+	// remote is the name of the Git remote, if any
+	remote string
+	// height is the block height the repository gets read at, 0 meaning the latest
+	height int64
+}
+
+// uri returns the repository's URI on the chain
+func (r repository) uri() string {
+	return fmt.Sprintf("%v/%v", r.owner, r.name)
 }
 
 func (r repository) String() string {
-	return fmt.Sprintf("%v/%v/%v", r.chainID, r.owner, r.name)
+	return fmt.Sprintf("%v/%v/%v", r.chain.id, r.owner, r.name)
+}
+
+// heightFlags returns the gitservicecli query flags for reading the repository at its height
+func (r repository) heightFlags() []string {
+	if r.height <= 0 {
+		return nil
+	}
+
+	return []string{"--height", strconv.FormatInt(r.height, 10)}
+}
+
+// parseHeight splits the optional height parameter off a URL, as in
+// joystream://chain/owner/name?height=<height>
+func parseHeight(url string) (string, int64, error) {
+	i := strings.Index(url, "?")
+	if i < 0 {
+		return url, 0, nil
+	}
+
+	values, err := neturl.ParseQuery(url[i+1:])
+	if err != nil {
+		return "", 0, err
+	}
+	var height int64
+	for key := range values {
+		if key != "height" {
+			return "", 0, fmt.Errorf("Unsupported URL parameter: '%s'", key)
+		}
+		height, err = strconv.ParseInt(values.Get(key), 10, 64)
+		if err != nil || height <= 0 {
+			return "", 0, fmt.Errorf("Invalid height in URL: '%s'", values.Get(key))
+		}
+	}
+
+	return url[:i], height, nil
 }
 
 func cmdRoot(_ *cobra.Command, args []string) error {
 	zerolog.SetGlobalLevel(zerolog.DebugLevel)
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 
-	var url string
+	var url, remote string
 	if len(args) == 1 {
 		url = args[0]
 	} else {
 		url = args[1]
+		// When pushing to a URL rather than a remote, Git passes the URL for both arguments
+		if args[0] != url {
+			remote = args[0]
+		}
+	}
+	url, height, err := parseHeight(url)
+	if err != nil {
+		return err
 	}
 	var m []string
 	if m = reJoystreamURL.FindStringSubmatch(url); m == nil {
 		return fmt.Errorf("URL on invalid format: '%v'", url)
 	}
-	repo := repository{chainID: m[1], owner: m[2], name: m[3]}
+	chain, err := lookupChain(m[1])
+	if err != nil {
+		return err
+	}
+	repo := repository{chain: chain, owner: m[2], name: m[3], remote: remote, height: height}
 
-	log.Debug().Msgf("Starting, repo: %v/%v/%v", repo.chainID, repo.owner, repo.name)
+	log.Debug().Msgf("Starting, repo: %v", repo)
 
+	opts := newOptions()
 	var pushBatch [][]string
 	reader := bufio.NewReader(os.Stdin)
 	// Read commands from stdin until closed
@@ -80,7 +204,7 @@ func cmdRoot(_ *cobra.Command, args []string) error {
 			log.Debug().Msgf("Received a blank line, command terminated")
 			if len(pushBatch) > 0 {
 				log.Debug().Msgf("Processing push batch")
-				if err := handlePushBatch(pushBatch, repo); err != nil {
+				if err := handlePushBatch(pushBatch, repo, opts); err != nil {
 					return err
 				}
 
@@ -90,11 +214,28 @@ func cmdRoot(_ *cobra.Command, args []string) error {
 			var err error
 			switch commandParts[0] {
 			case "capabilities":
-				fmt.Printf("push\n\n")
+				fmt.Printf("option\npush\nstateless-connect\n\n")
+			case "stateless-connect":
+				if len(commandParts) != 2 || commandParts[1] != "git-upload-pack" {
+					log.Debug().Msgf("Falling back for service %v", commandParts[1:])
+					fmt.Printf("fallback\n")
+					break
+				}
+
+				// After a stateless connection, the rest of the input belongs to it
+				return handleStatelessConnect(repo, opts, reader)
+			case "option":
+				// The option value may contain spaces
+				optionParts := strings.SplitN(command, " ", 3)
+				if len(optionParts) != 3 {
+					err = fmt.Errorf("Bad option request: %v", command)
+					break
+				}
+				fmt.Printf("%s\n", opts.set(optionParts[1], optionParts[2]))
 			case "list":
-				handleList(repo, commandParts[1:])
+				err = handleList(repo, opts, commandParts[1:])
 			case "push":
-				log.Debug().Msgf("Pushing - args: %v, %v", args[0], args[1])
+				log.Debug().Msgf("Pushing - args: %v", commandParts[1:])
 				pushBatch = append(pushBatch, commandParts[1:])
 				log.Debug().Msgf("Push batch: %v", pushBatch)
 			}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// options holds the options set by Git through the option command
+type options struct {
+	verbosity   int
+	progress    bool
+	dryRun      bool
+	atomic      bool
+	followTags  bool
+	pushOptions []string
+}
+
+func newOptions() *options {
+	return &options{verbosity: 1}
+}
+
+// set sets an option, returning the reply to Git ("ok", "unsupported" or "error <msg>")
+func (o *options) set(name, value string) string {
+	log.Debug().Msgf("Setting option '%s' to '%s'", name, value)
+	var err error
+	switch name {
+	case "verbosity":
+		o.verbosity, err = strconv.Atoi(value)
+		if err == nil {
+			zerolog.SetGlobalLevel(o.logLevel())
+		}
+	case "progress":
+		o.progress, err = strconv.ParseBool(value)
+	case "dry-run":
+		o.dryRun, err = strconv.ParseBool(value)
+	case "atomic":
+		o.atomic, err = strconv.ParseBool(value)
+	case "followtags":
+		o.followTags, err = strconv.ParseBool(value)
+	case "push-option":
+		o.pushOptions = append(o.pushOptions, value)
+	default:
+		log.Debug().Msgf("Option '%s' is unsupported", name)
+		return "unsupported"
+	}
+	if err != nil {
+		return fmt.Sprintf("error invalid value for option %s: '%s'", name, value)
+	}
+
+	return "ok"
+}
+
+// logLevel determines the log level corresponding to the verbosity
+func (o *options) logLevel() zerolog.Level {
+	switch {
+	case o.verbosity <= 0:
+		return zerolog.ErrorLevel
+	case o.verbosity == 1:
+		return zerolog.WarnLevel
+	default:
+		return zerolog.DebugLevel
+	}
+}
+
+// pushFlags returns the gitservicecli push-refs flags corresponding to the options
+func (o *options) pushFlags() []string {
+	var flags []string
+	if o.progress {
+		flags = append(flags, "--progress")
+	}
+	if o.dryRun {
+		flags = append(flags, "--dry-run")
+	}
+	if o.atomic {
+		flags = append(flags, "--atomic")
+	}
+	if o.followTags {
+		flags = append(flags, "--follow-tags")
+	}
+	for _, opt := range o.pushOptions {
+		flags = append(flags, "--push-option", opt)
+	}
+
+	return flags
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+)
+
+// readPktRequest reads a Git protocol v2 request, i.e. pkt-lines up until and including a flush
+// packet, into buf
+func readPktRequest(reader *bufio.Reader, buf *bytes.Buffer) error {
+	for {
+		var lenBuf [4]byte
+		if _, err := io.ReadFull(reader, lenBuf[:]); err != nil {
+			return err
+		}
+		buf.Write(lenBuf[:])
+
+		length, err := strconv.ParseUint(string(lenBuf[:]), 16, 16)
+		if err != nil {
+			return fmt.Errorf("Invalid pkt-line length: '%s'", lenBuf)
+		}
+		switch {
+		case length == 0:
+			// Flush packet, which terminates the request
+			return nil
+		case length < 4:
+			// Delimiter or response end packet
+			continue
+		}
+
+		if _, err := io.CopyN(buf, reader, int64(length-4)); err != nil {
+			return err
+		}
+	}
+}
+
+// handleStatelessConnect serves a stateless connection to git-upload-pack, by translating
+// each Git protocol v2 request into a gitservicecli upload-pack invocation
+func handleStatelessConnect(repo repository, opts *options, reader *bufio.Reader) error {
+	log.Debug().Msgf("Establishing stateless connection to git-upload-pack for %v", repo)
+	fmt.Printf("\n")
+
+	if err := runGitServiceCLI(repo, opts, nil, os.Stdout, "query", "gitService",
+		"upload-pack", repo.uri(), "--advertise-capabilities"); err != nil {
+		return err
+	}
+
+	for {
+		var req bytes.Buffer
+		if err := readPktRequest(reader, &req); err != nil {
+			if err == io.EOF && req.Len() == 0 {
+				log.Debug().Msgf("Stateless connection closed")
+				return nil
+			}
+
+			return err
+		}
+
+		log.Debug().Msgf("Forwarding request of %d bytes to gitservicecli", req.Len())
+		cliArgs := append([]string{"query", "gitService", "upload-pack", repo.uri()},
+			repo.heightFlags()...)
+		if err := runGitServiceCLI(repo, opts, &req, os.Stdout, cliArgs...); err != nil {
+			return err
+		}
+
+		// Tell Git that the response is complete
+		fmt.Printf("0002")
+	}
+}
@@ -21,7 +21,14 @@ import (
 )
 
 func main() {
-	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	logLevel := zerolog.DebugLevel
+	if levelStr := os.Getenv("GITSERVICECLI_LOG_LEVEL"); levelStr != "" {
+		var err error
+		if logLevel, err = zerolog.ParseLevel(levelStr); err != nil {
+			logLevel = zerolog.DebugLevel
+		}
+	}
+	zerolog.SetGlobalLevel(logLevel)
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 
 	cobra.EnableCommandSorting = false
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cosmos/cosmos-sdk/server"
+	app "github.com/joystream/onchain-git-poc"
+	"github.com/joystream/onchain-git-poc/x/gitService"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tendermint/tendermint/libs/cli"
+	dbm "github.com/tendermint/tendermint/libs/db"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+const flagHeight = "height"
+
+// defaultConfig is the config of exported repositories that have none stored
+const defaultConfig = `[core]
+	repositoryformatversion = 0
+	bare = true
+`
+
+// ExportRepoCmd exports a repository from the node's database as a bare Git repository
+func ExportRepoCmd(ctx *server.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export-repo owner/name directory",
+		Short: "Export a repository from the node's database as a bare Git repository",
+		Long: `Export a repository from the node's database as a bare Git repository, which plain
+Git can clone from. The node mustn't be running, as the database can only be opened by one
+process. Older heights are only available if the node doesn't prune them.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(_ *cobra.Command, args []string) error {
+			components := strings.Split(args[0], "/")
+			if len(components) != 2 || components[0] == "" || components[1] == "" {
+				return fmt.Errorf("invalid repository URI: '%s'", args[0])
+			}
+			dir := args[1]
+			if _, err := os.Stat(dir); err == nil {
+				return fmt.Errorf("%s already exists", dir)
+			}
+
+			home := viper.GetString(cli.HomeFlag)
+			db, err := dbm.NewGoLevelDB("application", filepath.Join(home, "data"))
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			height := viper.GetInt64(flagHeight)
+			dapp := app.NewGitServiceApp(ctx.Logger, db, height <= 0)
+			if height > 0 {
+				if err := dapp.LoadHeight(height); err != nil {
+					return err
+				}
+			}
+			repo, err := dapp.ExportRepository(components[0], components[1])
+			if err != nil {
+				return err
+			}
+			if repo == nil {
+				return fmt.Errorf("repository '%s' doesn't exist", args[0])
+			}
+
+			if err := writeBareRepo(dir, repo); err != nil {
+				return err
+			}
+			fmt.Printf("Exported repository %s at height %d to %s\n", args[0],
+				dapp.LastBlockHeight(), dir)
+			return nil
+		},
+	}
+	cmd.Flags().Int64(flagHeight, 0, "Export the repository at a height (0 means latest height)")
+
+	return cmd
+}
+
+// writeBareRepo writes an exported repository as a bare Git repository. Symbolic references
+// get written as loose references, and the others to packed-refs.
+func writeBareRepo(dir string, repo *gitService.GenesisRepository) error {
+	for _, sub := range []string{"objects/pack", "objects/info", "refs/heads", "refs/tags"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return err
+		}
+	}
+
+	config := repo.Config
+	if config == "" {
+		config = defaultConfig
+	}
+	files := map[string][]byte{"config": []byte(config)}
+	if repo.Repository.Description != "" {
+		files["description"] = []byte(repo.Repository.Description + "\n")
+	}
+
+	refs := append([]gitService.GenesisReference{}, repo.Refs...)
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+	packedRefs := bytes.NewBufferString("# pack-refs with: sorted \n")
+	for _, ref := range refs {
+		if ref.Name == plumbing.HEAD || strings.HasPrefix(ref.Target, "ref: ") {
+			files[ref.Name.String()] = []byte(ref.Target + "\n")
+			continue
+		}
+		fmt.Fprintf(packedRefs, "%s %s\n", ref.Target, ref.Name)
+	}
+	files["packed-refs"] = packedRefs.Bytes()
+
+	for _, pack := range repo.Packfiles {
+		checksum, err := pack.Checksum()
+		if err != nil {
+			return err
+		}
+		path := fmt.Sprintf("objects/pack/pack-%s", checksum)
+		files[path+".pack"] = pack.Pack
+		files[path+".idx"] = pack.Index
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/cosmos/cosmos-sdk/baseapp"
 	"github.com/cosmos/cosmos-sdk/client"
 	"github.com/cosmos/cosmos-sdk/codec"
 	"github.com/cosmos/cosmos-sdk/server"
@@ -22,6 +23,7 @@ import (
 	gaiaInit "github.com/cosmos/cosmos-sdk/cmd/gaia/init"
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	app "github.com/joystream/onchain-git-poc"
+	"github.com/joystream/onchain-git-poc/x/gitService"
 	abci "github.com/tendermint/tendermint/abci/types"
 	cfg "github.com/tendermint/tendermint/config"
 	dbm "github.com/tendermint/tendermint/libs/db"
@@ -31,7 +33,8 @@ import (
 var defaultNodeHome = os.ExpandEnv("$HOME/.gitserviced")
 
 const (
-	flagOverwrite = "overwrite"
+	flagOverwrite               = "overwrite"
+	flagRequireExplicitCreation = "require-explicit-creation"
 )
 
 func main() {
@@ -48,6 +51,7 @@ func main() {
 
 	rootCmd.AddCommand(InitCmd(ctx, cdc))
 	rootCmd.AddCommand(AddGenesisAccountCmd(ctx, cdc))
+	rootCmd.AddCommand(ExportRepoCmd(ctx))
 
 	server.AddCommands(ctx, cdc, rootCmd, newApp, exportAppStateAndTMValidators)
 
@@ -59,12 +63,13 @@ func main() {
 }
 
 func newApp(logger log.Logger, db dbm.DB, traceStore io.Writer) abci.Application {
-	return app.NewGitServiceApp(logger, db)
+	return app.NewGitServiceApp(logger, db, true,
+		baseapp.SetPruning(viper.GetString("pruning")))
 }
 
 func exportAppStateAndTMValidators(logger log.Logger, db dbm.DB, _ io.Writer, _ int64, _ bool) (
 	json.RawMessage, []tmtypes.GenesisValidator, error) {
-	dapp := app.NewGitServiceApp(logger, db)
+	dapp := app.NewGitServiceApp(logger, db, true)
 	return dapp.ExportAppStateAndValidators()
 }
 
@@ -95,7 +100,11 @@ func InitCmd(ctx *server.Context, cdc *codec.Codec) *cobra.Command {
 				return fmt.Errorf("genesis.json file already exists: %v", genFile)
 			}
 
-			appState, err = codec.MarshalJSONIndent(cdc, app.GenesisState{})
+			gitServiceGenesis := gitService.DefaultGenesisState()
+			gitServiceGenesis.RequireExplicitCreation = viper.GetBool(flagRequireExplicitCreation)
+			appState, err = codec.MarshalJSONIndent(cdc, app.GenesisState{
+				GitService: gitServiceGenesis,
+			})
 			if err != nil {
 				return err
 			}
@@ -122,6 +131,8 @@ func InitCmd(ctx *server.Context, cdc *codec.Codec) *cobra.Command {
 	cmd.Flags().String(cli.HomeFlag, defaultNodeHome, "node's home directory")
 	cmd.Flags().String(client.FlagChainID, "", "genesis file chain-id, if left blank will be randomly created")
 	cmd.Flags().BoolP(flagOverwrite, "o", false, "overwrite the genesis.json file")
+	cmd.Flags().Bool(flagRequireExplicitCreation, false,
+		"require repositories to be created before they can be pushed to")
 
 	return cmd
 }
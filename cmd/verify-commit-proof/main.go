@@ -0,0 +1,78 @@
+// Standalone verifier of the proofs written by gitservicecli query gitService prove-commit
+//
+// The verification is offline: it checks that the validators signed the header, that the
+// header's app hash proves the packfile index and that the index contains the object. What's
+// left to trust is the validator set, whose hash gets reported or may be required up front.
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/cosmos/cosmos-sdk/codec"
+	"github.com/joystream/onchain-git-poc/x/gitService"
+	"github.com/spf13/cobra"
+)
+
+const flagValidatorsHash = "validators-hash"
+
+func cmdRoot(cmd *cobra.Command, args []string) error {
+	b, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	cdc := codec.New()
+	codec.RegisterCrypto(cdc)
+	var proof gitService.CommitProof
+	if err := cdc.UnmarshalJSON(b, &proof); err != nil {
+		return err
+	}
+	if err := proof.Verify(); err != nil {
+		return fmt.Errorf("Proof doesn't verify: %s", err)
+	}
+
+	validatorsHash := proof.ValidatorsHash()
+	expected, err := cmd.Flags().GetString(flagValidatorsHash)
+	if err != nil {
+		return err
+	}
+	if expected != "" {
+		expectedBytes, err := hex.DecodeString(strings.TrimPrefix(expected, "0x"))
+		if err != nil {
+			return fmt.Errorf("Invalid validators hash: '%s'", expected)
+		}
+		if !bytes.Equal(expectedBytes, validatorsHash) {
+			return fmt.Errorf("Proof is signed by validators %X rather than %s", validatorsHash,
+				expected)
+		}
+	}
+
+	fmt.Printf("Object %s was stored in repository %s on chain %s from height %d\n",
+		proof.Object, proof.URI, proof.ChainID, proof.Height)
+	fmt.Printf("Packfile: %s\n", proof.Pack)
+	fmt.Printf("Time of block %d: %s\n", proof.SignedHeader.Height,
+		proof.SignedHeader.Time.UTC())
+	fmt.Printf("Validators hash: %X\n", validatorsHash)
+
+	return nil
+}
+
+func main() {
+	rootCmd := &cobra.Command{
+		Use:          "verify-commit-proof proof.json",
+		Short:        "Verify a proof that a commit was stored on the blockchain, offline",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE:         cmdRoot,
+	}
+	rootCmd.Flags().String(flagValidatorsHash, "",
+		"Require the proof to be signed by the validator set of this hash")
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"strings"
 
 	cosmosContext "github.com/cosmos/cosmos-sdk/client/context"
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	authtxb "github.com/cosmos/cosmos-sdk/x/auth/client/txbuilder"
+	"github.com/joystream/onchain-git-poc/x/gitService"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	gogit "gopkg.in/src-d/go-git.v4"
@@ -31,6 +33,27 @@ var (
 	errForceNeeded           = errors.New("some refs were not updated")
 )
 
+// pushOptions holds options for pushing references, corresponding to Git push options
+type pushOptions struct {
+	// atomic says to refuse the whole push if any of the references can't be updated
+	atomic bool
+	// followTags says to also push annotated tags that point into the pushed history
+	followTags bool
+	// progress says to report progress on stderr
+	progress bool
+	// serverOptions are push options (as given to git push -o) to transmit to the chain
+	serverOptions []string
+	// defaultBranch is the branch for HEAD to point to, in case the push creates the repository
+	defaultBranch plumbing.ReferenceName
+}
+
+// progressf reports progress on stderr, if enabled
+func (o *pushOptions) progressf(format string, args ...interface{}) {
+	if o.progress {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+}
+
 // DummyAuth is a preliminary authentication method
 type DummyAuth struct {
 	Username, Password string
@@ -62,7 +85,7 @@ func checkFastForwardUpdate(repo *gogit.Repository, remoteRefs storer.ReferenceS
 			return err
 		}
 
-		return fmt.Errorf("non-fast-forward update: %s", cmd.Name.String())
+		return errors.New(gitService.GitStatus(gitService.CodeNonFastForward))
 	}
 
 	ff, err := isFastForward(repo, cmd.Old, cmd.New)
@@ -71,7 +94,7 @@ func checkFastForwardUpdate(repo *gogit.Repository, remoteRefs storer.ReferenceS
 	}
 
 	if !ff {
-		return fmt.Errorf("non-fast-forward update: %s", cmd.Name.String())
+		return errors.New(gitService.GitStatus(gitService.CodeNonFastForward))
 	}
 
 	return nil
@@ -189,34 +212,38 @@ func referencesToHashes(refs storer.ReferenceStorer) ([]plumbing.Hash, error) {
 	return hashes, nil
 }
 
-// pushToBlockchain sends a message to the server to update a set of references
+// pushToBlockchain sends a message to the server to update a set of references. The returned
+// report contains the status of each reference, including references rejected client side.
 func pushToBlockChain(ctx context.Context, uri string, refSpecs []gogitcfg.RefSpec,
 	repo *gogit.Repository, cliCtx cosmosContext.CLIContext,
-	txBldr authtxb.TxBuilder, author sdk.AccAddress, moduleName string) error {
+	txBldr authtxb.TxBuilder, author sdk.AccAddress, passphrase string, opts *pushOptions,
+	moduleName string) (rs *packp.ReportStatus, err error) {
 	// TODO: Verify that URL is of joystream protocol
 	log.Debug().Msgf("Pushing '%s' to blockchain at '%s'", refSpecs[0], uri)
-	c, err := newJoystreamClient(uri, cliCtx, txBldr, author, moduleName)
+	c, err := newJoystreamClient(uri, cliCtx, txBldr, author, passphrase, opts, moduleName)
 	if err != nil {
 		log.Debug().Msgf("Failed to create client for URL '%s'", uri)
-		return err
+		return nil, err
 	}
+	c.refPrefixes = pushRefPrefixes(refSpecs, opts.followTags)
 
 	// Start a session for uploading data to the endpoint
 	log.Debug().Msgf("Starting session")
 	session, err := c.NewReceivePackSession(c.ep, &DummyAuth{})
 	if err != nil {
 		log.Debug().Msgf("Failed opening session for URL '%s'", uri)
-		return err
+		return nil, err
 	}
 	defer ioutil.CheckClose(session, &err)
 
+	opts.progressf("Getting advertised references of %s", uri)
 	advRefs, err := session.AdvertisedReferences()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	remoteRefs, err := advRefs.AllReferences()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	allDelete := true
@@ -232,7 +259,7 @@ func pushToBlockChain(ctx context.Context, uri string, refSpecs []gogitcfg.RefSp
 
 	localRefs, err := getReferences(repo)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	localRefStrings := make([]string, 0, len(localRefs))
@@ -241,12 +268,28 @@ func pushToBlockChain(ctx context.Context, uri string, refSpecs []gogitcfg.RefSp
 	}
 	log.Debug().Msgf("Got local references: %v", strings.Join(localRefStrings, ", "))
 	req := packp.NewReferenceUpdateRequest()
-	if err := computeRefUpdateCmds(refSpecs, localRefs, remoteRefs, repo, req); err != nil {
-		return err
+	rejected, err := computeRefUpdateCmds(refSpecs, localRefs, remoteRefs, repo, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(rejected) > 0 && opts.atomic {
+		for name, err := range rejected {
+			log.Debug().Msgf("Atomic push failed due to reference '%s': %s", name, err)
+		}
+		return rejectedReport(rejected), errors.New("atomic push failed")
+	}
+	if opts.followTags && !allDelete {
+		if err := addFollowTags(localRefs, remoteRefs, repo, req); err != nil {
+			return nil, err
+		}
 	}
 	if len(req.Commands) == 0 {
+		if len(rejected) > 0 {
+			return rejectedReport(rejected), nil
+		}
+
 		log.Debug().Msgf("Remote is already up to date")
-		return errAlreadyUpToDate
+		return nil, errAlreadyUpToDate
 	}
 
 	var hashesToPush []plumbing.Hash
@@ -254,17 +297,123 @@ func pushToBlockChain(ctx context.Context, uri string, refSpecs []gogitcfg.RefSp
 	if !allDelete {
 		hashesToPush, err = getHashesToPush(req, repo, remoteRefs)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
+	opts.progressf("Pushing %d reference(s) and %d object(s)", len(req.Commands),
+		len(hashesToPush))
 	reportStatus, err := pushHashes(ctx, session, repo, uri, req, hashesToPush, advRefs)
-	if err != nil {
-		return err
+	if reportStatus != nil {
+		reportStatus.CommandStatuses = append(reportStatus.CommandStatuses,
+			rejectedReport(rejected).CommandStatuses...)
 	}
 
-	if err := reportStatus.Error(); err != nil {
-		return err
+	return reportStatus, err
+}
+
+// pushRefPrefixes determines the prefixes of the remote references that need to be advertised
+// for pushing a set of refspecs, i.e. their destinations. The tags are needed as well when
+// following tags.
+func pushRefPrefixes(refSpecs []gogitcfg.RefSpec, followTags bool) []string {
+	var prefixes []string
+	if followTags {
+		prefixes = append(prefixes, "refs/tags/")
+	}
+	for _, refSpec := range refSpecs {
+		spec := string(refSpec)
+		dst := spec[strings.Index(spec, ":")+1:]
+		if i := strings.Index(dst, "*"); i >= 0 {
+			dst = dst[:i]
+		}
+
+		prefixes = append(prefixes, dst)
+	}
+
+	return prefixes
+}
+
+// rejectedReport makes a report of references rejected client side
+func rejectedReport(rejected map[plumbing.ReferenceName]error) *packp.ReportStatus {
+	rs := packp.NewReportStatus()
+	rs.UnpackStatus = "ok"
+	for name, err := range rejected {
+		rs.CommandStatuses = append(rs.CommandStatuses, &packp.CommandStatus{
+			ReferenceName: name,
+			Status:        err.Error(),
+		})
+	}
+
+	return rs
+}
+
+// addFollowTags adds commands for creating annotated tags that are missing from the remote and
+// point to commits reachable from the references being pushed
+func addFollowTags(localRefs []*plumbing.Reference, remoteRefs storer.ReferenceStorer,
+	repo *gogit.Repository, req *packp.ReferenceUpdateRequest) error {
+	log.Debug().Msgf("Determining annotated tags to push along")
+	reachable := make(map[plumbing.Hash]bool)
+	pending := make(map[plumbing.ReferenceName]bool)
+	for _, cmd := range req.Commands {
+		pending[cmd.Name] = true
+		if cmd.New == plumbing.ZeroHash {
+			continue
+		}
+
+		c, err := object.GetCommit(repo.Storer, cmd.New)
+		if err == plumbing.ErrObjectNotFound {
+			// Not a commit
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = object.NewCommitPreorderIter(c, reachable, nil).ForEach(func(c *object.Commit) error {
+			reachable[c.Hash] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, ref := range localRefs {
+		if !ref.Name().IsTag() || ref.Type() != plumbing.HashReference || pending[ref.Name()] {
+			continue
+		}
+
+		tag, err := object.GetTag(repo.Storer, ref.Hash())
+		if err == plumbing.ErrObjectNotFound {
+			// A lightweight tag
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// Peel tags of tags
+		for tag.TargetType == plumbing.TagObject {
+			if tag, err = object.GetTag(repo.Storer, tag.Target); err != nil {
+				return err
+			}
+		}
+		c, err := tag.Commit()
+		if err != nil || !reachable[c.Hash] {
+			continue
+		}
+
+		if _, err := remoteRefs.Reference(ref.Name()); err == nil {
+			continue
+		} else if err != plumbing.ErrReferenceNotFound {
+			return err
+		}
+
+		log.Debug().Msgf("Adding command to push along tag '%s' -> '%s'", ref.Name(), ref.Hash())
+		req.Commands = append(req.Commands, &packp.Command{
+			Name: ref.Name(),
+			Old:  plumbing.ZeroHash,
+			New:  ref.Hash(),
+		})
 	}
 
 	return nil
@@ -325,10 +474,12 @@ func pushHashes(ctx context.Context, sess transport.ReceivePackSession, repo *go
 	return reportStatus, nil
 }
 
-// computeRefUpdateCmds determines reference update commands
+// computeRefUpdateCmds determines reference update commands. References that can't be
+// updated, f.ex. since the update isn't a fast-forward, are returned as rejected.
 func computeRefUpdateCmds(refSpecs []gogitcfg.RefSpec, localRefs []*plumbing.Reference,
 	remoteRefs storer.ReferenceStorer, repo *gogit.Repository,
-	req *packp.ReferenceUpdateRequest) error {
+	req *packp.ReferenceUpdateRequest) (map[plumbing.ReferenceName]error, error) {
+	rejected := make(map[plumbing.ReferenceName]error)
 	name2LocalRef := make(map[string]*plumbing.Reference)
 	for _, ref := range localRefs {
 		name2LocalRef[ref.Name().String()] = ref
@@ -341,7 +492,7 @@ func computeRefUpdateCmds(refSpecs []gogitcfg.RefSpec, localRefs []*plumbing.Ref
 		if refSpec.IsDelete() {
 			log.Debug().Msgf("It's a deletion")
 			if err := deleteReferences(refSpec, remoteRefs, req); err != nil {
-				return err
+				return nil, err
 			}
 		} else {
 			log.Debug().Msgf("It's not a deletion")
@@ -350,7 +501,7 @@ func computeRefUpdateCmds(refSpecs []gogitcfg.RefSpec, localRefs []*plumbing.Ref
 			if !refSpec.IsWildcard() {
 				refName, err := resolveLocalRef(refSpec, repo)
 				if err != nil {
-					return err
+					return nil, err
 				}
 
 				localRef, ok := name2LocalRef[refName.String()]
@@ -360,14 +511,16 @@ func computeRefUpdateCmds(refSpecs []gogitcfg.RefSpec, localRefs []*plumbing.Ref
 					continue
 				}
 
-				if err := addReference(refSpec, remoteRefs, localRef, req, repo); err != nil {
-					return err
+				if err := addReference(refSpec, remoteRefs, localRef, req, repo,
+					rejected); err != nil {
+					return nil, err
 				}
 			} else {
 				for _, localRef := range localRefs {
 					if refSpec.Match(localRef.Name()) {
-						if err := addReference(refSpec, remoteRefs, localRef, req, repo); err != nil {
-							return err
+						if err := addReference(refSpec, remoteRefs, localRef, req, repo,
+							rejected); err != nil {
+							return nil, err
 						}
 					}
 				}
@@ -375,15 +528,18 @@ func computeRefUpdateCmds(refSpecs []gogitcfg.RefSpec, localRefs []*plumbing.Ref
 		}
 	}
 
-	return nil
+	return rejected, nil
 }
 
 // addReference adds a command for adding or updating a reference to a ReferenceUpdateRequest,
-// if required conditions are met
+// if required conditions are met. If they aren't, the reference gets added to rejected.
 func addReference(refSpec gogitcfg.RefSpec, remoteRefs storer.ReferenceStorer,
-	localRef *plumbing.Reference, req *packp.ReferenceUpdateRequest, repo *gogit.Repository) error {
+	localRef *plumbing.Reference, req *packp.ReferenceUpdateRequest, repo *gogit.Repository,
+	rejected map[plumbing.ReferenceName]error) error {
 	log.Debug().Msgf("Determining whether to add a command to ReferenceUpdateRequest")
 	if localRef.Type() != plumbing.HashReference {
+		// Symbolic references get pushed through their targets, e.g. HEAD as the current branch
+		log.Debug().Msgf("Skipping symbolic reference '%s'", localRef.Name())
 		return nil
 	}
 
@@ -404,12 +560,22 @@ func addReference(refSpec gogitcfg.RefSpec, remoteRefs storer.ReferenceStorer,
 
 	remoteRef, err := remoteRefs.Reference(cmd.Name)
 	if err == nil {
-		if remoteRef.Type() != plumbing.HashReference {
-			// TODO: check actual git behavior here
-			return nil
+		if remoteRef.Type() == plumbing.SymbolicReference {
+			// Like Git, update the reference pointed to
+			resolved, err := storer.ResolveReference(remoteRefs, cmd.Name)
+			if err != nil && err != plumbing.ErrReferenceNotFound {
+				return err
+			}
+			log.Debug().Msgf("Remote reference '%s' is symbolic, updating '%s' instead",
+				cmd.Name, remoteRef.Target())
+			cmd.Name = remoteRef.Target()
+			if resolved != nil {
+				remoteRef = resolved
+			}
+		}
+		if remoteRef.Type() == plumbing.HashReference {
+			cmd.Old = remoteRef.Hash()
 		}
-
-		cmd.Old = remoteRef.Hash()
 	} else if err != plumbing.ErrReferenceNotFound {
 		return err
 	}
@@ -418,6 +584,13 @@ func addReference(refSpec gogitcfg.RefSpec, remoteRefs storer.ReferenceStorer,
 		return nil
 	}
 
+	if cmd.Name.IsTag() && cmd.Old != plumbing.ZeroHash && !refSpec.IsForceUpdate() {
+		// Tags are meant to be immutable, and annotated ones aren't commits to fast-forward
+		log.Debug().Msgf("Rejecting update of existing tag '%s'", cmd.Name)
+		rejected[cmd.Name] = errors.New(gitService.GitStatus(gitService.CodeRefExists))
+		return nil
+	}
+
 	if cmd.Old == plumbing.ZeroHash {
 		log.Debug().Msgf("Adding reference to remote %s -> %s", cmd.Name, cmd.New)
 	} else {
@@ -427,7 +600,9 @@ func addReference(refSpec gogitcfg.RefSpec, remoteRefs storer.ReferenceStorer,
 	if !refSpec.IsForceUpdate() {
 		log.Debug().Msgf("Not in force mode - verifying update is a fast forward")
 		if err := checkFastForwardUpdate(repo, remoteRefs, cmd); err != nil {
-			return err
+			log.Debug().Msgf("Rejecting update of reference '%s': %s", cmd.Name, err)
+			rejected[cmd.Name] = err
+			return nil
 		}
 	}
 
@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// GitConfig gets the value of a Git config key, or an empty string if it isn't set. Extra
+// arguments, e.g. --bool or --path, get passed to git config.
+func GitConfig(key string, extraArgs ...string) (string, error) {
+	args := append([]string{"config"}, extraArgs...)
+	args = append(args, "--get", key)
+	var stdout bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		// git config exits with status 1 when the key isn't set
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// firstGitConfig gets the value of the first of a set of Git config keys that is set
+func firstGitConfig(keys ...string) (string, error) {
+	for _, key := range keys {
+		value, err := GitConfig(key)
+		if err != nil {
+			return "", err
+		}
+		if value != "" {
+			log.Debug().Msgf("Got Git config '%s': '%s'", key, value)
+			return value, nil
+		}
+	}
+
+	return "", nil
+}
@@ -4,12 +4,12 @@ import (
 	"bytes"
 	"context"
 	encJson "encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"regexp"
 
 	cosmosContext "github.com/cosmos/cosmos-sdk/client/context"
-	"github.com/cosmos/cosmos-sdk/client/utils"
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	authtxb "github.com/cosmos/cosmos-sdk/x/auth/client/txbuilder"
 	"github.com/joystream/onchain-git-poc/x/gitService"
@@ -25,13 +25,18 @@ type joystreamClient struct {
 	txBldr     authtxb.TxBuilder
 	cliCtx     cosmosContext.CLIContext
 	author     sdk.AccAddress
+	passphrase string
+	opts       *pushOptions
 	moduleName string
+	// refPrefixes restricts the advertised references to those needed for pushing
+	refPrefixes []string
 }
 
 var reRepoURI = regexp.MustCompile("^[^/]+/[^/]+$")
 
 func newJoystreamClient(uri string, cliCtx cosmosContext.CLIContext, txBldr authtxb.TxBuilder,
-	author sdk.AccAddress, moduleName string) (*joystreamClient, error) {
+	author sdk.AccAddress, passphrase string, opts *pushOptions, moduleName string) (
+	*joystreamClient, error) {
 	if !reRepoURI.MatchString(uri) {
 		return nil, fmt.Errorf("Repo URI on invalid format: '%s'", uri)
 	}
@@ -47,6 +52,8 @@ func newJoystreamClient(uri string, cliCtx cosmosContext.CLIContext, txBldr auth
 		txBldr:     txBldr,
 		cliCtx:     cliCtx,
 		author:     author,
+		passphrase: passphrase,
+		opts:       opts,
 		moduleName: moduleName,
 	}, nil
 }
@@ -83,20 +90,55 @@ func (c *joystreamClient) NewReceivePackSession(ep *transport.Endpoint,
 func (s *rpSession) AdvertisedReferences() (*packp.AdvRefs, error) {
 	log.Debug().Msgf("Joystream client getting advertised references")
 
-	queryPath := fmt.Sprintf("custom/%s/advertisedReferences/%s", s.client.moduleName,
-		s.client.ep.Path[1:])
-	log.Debug().Msgf("Joystream client making query, path: '%s'", queryPath)
-	res, err := s.client.cliCtx.QueryWithData(queryPath, nil)
+	uri := s.client.ep.Path[1:]
+	cliCtx, err := pinProvableHeight(s.client.cliCtx)
 	if err != nil {
 		return nil, err
 	}
-
+	queryPath := fmt.Sprintf("custom/%s/advertisedReferences/%s", s.client.moduleName, uri)
+	query := gitService.RefsQuery{RefPrefixes: s.client.refPrefixes}
 	var advRefs *packp.AdvRefs
-	if err := encJson.Unmarshal(res, &advRefs); err != nil {
-		return nil, err
+	for {
+		data, err := encJson.Marshal(query)
+		if err != nil {
+			return nil, err
+		}
+		log.Debug().Msgf("Joystream client making query, path: '%s', data: '%s'", queryPath, data)
+		res, err := cliCtx.QueryWithData(queryPath, data)
+		if err != nil {
+			return nil, err
+		}
+
+		var resp gitService.AdvertisedReferencesResponse
+		if err := encJson.Unmarshal(res, &resp); err != nil {
+			return nil, err
+		}
+		if advRefs == nil {
+			advRefs = resp.AdvRefs
+		} else {
+			// Subsequent pages only contribute references
+			for name, h := range resp.AdvRefs.References {
+				advRefs.References[name] = h
+			}
+			for name, h := range resp.AdvRefs.Peeled {
+				advRefs.Peeled[name] = h
+			}
+		}
+		if resp.Next == "" {
+			break
+		}
+
+		query.Start = resp.Next
 	}
 	log.Debug().Msgf("Joystream client got advertised references from server: %+v",
 		advRefs.References)
+	if !cliCtx.TrustNode {
+		if err := newRefVerifier(cliCtx, uri).verifyAdvRefs(advRefs, gitService.RefsQuery{
+			RefPrefixes: s.client.refPrefixes,
+		}); err != nil {
+			return nil, err
+		}
+	}
 
 	return advRefs, nil
 }
@@ -112,20 +154,25 @@ func (s *rpSession) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateR
 	// TODO: Make references update atomic
 
 	log.Debug().Msgf("Joystream client encoding packfile...")
+	opts := s.client.opts
+	opts.progressf("Encoding packfile")
 	buf := bytes.NewBuffer(nil)
 	if _, err := io.Copy(buf, req.Packfile); err != nil {
 		log.Debug().Msgf("Joystream client failed to encode packfile: %s", err)
 		req.Packfile.Close()
+		s.unpackErr = err
 		return s.reportStatus(), err
 	}
 	if err := req.Packfile.Close(); err != nil {
+		s.unpackErr = err
 		return s.reportStatus(), err
 	}
+	opts.progressf("Encoded packfile, %d bytes", buf.Len())
 
 	repoURI := s.endpoint.Path[1:]
 	log.Debug().Msgf("Creating MsgUpdateReferences, repo URI: '%s'", s.endpoint.Path)
 	msg, err := gitService.NewMsgUpdateReferences(repoURI, req, buf.Bytes(),
-		s.client.author)
+		opts.serverOptions, opts.defaultBranch, s.client.author)
 	if err != nil {
 		log.Debug().Msgf("Joystream client failed to create MsgUpdateReferences: %s", err)
 		return s.reportStatus(), err
@@ -134,36 +181,47 @@ func (s *rpSession) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateR
 		"Joystream client sending MsgUpdateReferences to server for repo '%s' with %d command(s)",
 		msg.URI, len(msg.Commands))
 
-	if err := utils.CompleteAndBroadcastTxCli(s.client.txBldr, s.client.cliCtx,
-		[]sdk.Msg{msg}); err != nil {
-		log.Debug().Msgf("Sending MsgUpdateReferences to node failed: %s", err)
-		return s.reportStatus(), err
+	opts.progressf("Broadcasting transaction")
+	res, broadcastErr := completeAndBroadcastTx(s.client.txBldr, s.client.cliCtx,
+		[]sdk.Msg{msg}, s.client.passphrase)
+	if res != nil && res.CheckTx.IsOK() && len(res.DeliverTx.Data) > 0 {
+		// The message got handled, so the server reports the outcome per reference
+		report, err := gitService.DecodePushReport(s.client.cliCtx.Codec, res.DeliverTx.Data)
+		if err != nil {
+			log.Debug().Msgf("Decoding push report failed: %s", err)
+			return nil, err
+		}
+		log.Debug().Msgf("Joystream client got push report from server: %+v", report)
+		if broadcastErr != nil {
+			opts.progressf("Transaction %s failed at block %d", res.Hash, res.Height)
+		} else {
+			opts.progressf("Transaction %s committed at block %d, stored %d object(s) in pack %s",
+				res.Hash, res.Height, report.Objects, report.PackID)
+		}
+
+		return report.ReportStatus(), nil
 	}
 
-	return s.reportStatus(), nil
+	status := broadcastErr
+	if res != nil && res.CheckTx.Codespace == string(gitService.DefaultCodespace) {
+		// The message got rejected, e.g. by failing validation, so report the reason like Git
+		status = errors.New(gitService.GitStatus(sdk.CodeType(res.CheckTx.Code)))
+	}
+	for _, cmd := range req.Commands {
+		s.setStatus(cmd.Name, status)
+	}
+	if broadcastErr != nil {
+		log.Debug().Msgf("Sending MsgUpdateReferences to node failed: %s", broadcastErr)
+		return s.reportStatus(), broadcastErr
+	}
+	if res != nil {
+		// Broadcast asynchronously, so the outcome isn't known until the transaction is committed
+		opts.progressf("Transaction %s broadcast, pending inclusion in a block", res.Hash)
+		return s.reportStatus(), nil
+	}
+	opts.progressf("Dry run, transaction not broadcast")
 
-	// Encode as blob to send to server
-	// buf := bytes.NewBuffer(nil)
-	// if err := req.Encode(buf); err != nil {
-	// 	return nil, err
-	// }
-
-	// req := packp.NewReferenceUpdateRequest()
-	// if err := req.Decode(buf); err != nil {
-	// 	return fmt.Errorf("error decoding: %s", err)
-	// }
-
-	// reportStatus := packp.NewReportStatus()
-	// reportStatus.CommandStatuses = []*packp.CommandStatus{}
-	// reportStatus.UnpackStatus = "ok"
-	// error := reportStatus.Error()
-	// if error != nil {
-	// 	log.Debug().Msgf("Error making report status: %s", error)
-	// 	return nil, error
-	// }
-
-	// log.Debug().Msgf("Returning report status: %v", reportStatus)
-	// return reportStatus, nil
+	return s.reportStatus(), nil
 }
 
 func (s *rpSession) reportStatus() *packp.ReportStatus {
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Special packets of the Git pkt-line format. go-git only knows about flush packets, whereas
+// protocol v2 also uses delimiter and response end packets.
+const (
+	pktFlush       = "0000"
+	pktDelim       = "0001"
+	pktResponseEnd = "0002"
+)
+
+// readPktLine reads a pkt-line, returning either its payload or, in case of a special packet,
+// the packet itself
+func readPktLine(r io.Reader) (payload []byte, special string, err error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, "", err
+	}
+
+	switch lenStr := string(lenBuf[:]); lenStr {
+	case pktFlush, pktDelim, pktResponseEnd:
+		return nil, lenStr, nil
+	default:
+		length, err := strconv.ParseUint(lenStr, 16, 16)
+		if err != nil || length < 4 {
+			return nil, "", fmt.Errorf("Invalid pkt-line length: '%s'", lenStr)
+		}
+
+		payload = make([]byte, length-4)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, "", err
+		}
+
+		return payload, "", nil
+	}
+}
+
+// writePktLine writes a pkt-line with a formatted payload
+func writePktLine(w io.Writer, format string, args ...interface{}) error {
+	payload := fmt.Sprintf(format, args...)
+	_, err := fmt.Fprintf(w, "%04x%s", len(payload)+4, payload)
+	return err
+}
+
+// writeSpecialPkt writes a special packet, e.g. a flush packet
+func writeSpecialPkt(w io.Writer, pkt string) error {
+	_, err := io.WriteString(w, pkt)
+	return err
+}
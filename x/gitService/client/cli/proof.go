@@ -0,0 +1,545 @@
+package cli
+
+import (
+	"bytes"
+	encJson "encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cosmos/cosmos-sdk/client/context"
+	"github.com/cosmos/cosmos-sdk/codec"
+	"github.com/cosmos/cosmos-sdk/store"
+	"github.com/joystream/onchain-git-poc/x/gitService"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/tendermint/tendermint/crypto/merkle"
+	rpcclient "github.com/tendermint/tendermint/rpc/client"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+)
+
+// pinProvableHeight pins the height of queries to an untrusted node to the latest one whose
+// state can be proven, so that a listing and the proofs of its references refer to the same
+// state. Queries to trusted nodes, or at an explicit height, are left alone.
+func pinProvableHeight(cliCtx context.CLIContext) (context.CLIContext, error) {
+	if cliCtx.TrustNode || cliCtx.Height > 0 {
+		return cliCtx, nil
+	}
+
+	node, err := cliCtx.GetNode()
+	if err != nil {
+		return cliCtx, err
+	}
+	status, err := node.Status()
+	if err != nil {
+		return cliCtx, err
+	}
+	// The app hash of the state at a height is in the header of the next block
+	height := status.SyncInfo.LatestBlockHeight - 1
+	if height < 1 {
+		return cliCtx, fmt.Errorf("The chain has no provable state yet")
+	}
+
+	log.Debug().Msgf("Pinning queries to provable height %d", height)
+	cliCtx.Height = height
+	return cliCtx, nil
+}
+
+// queryProven queries the value of a key of the git store, verifying the merkle proof of the
+// value, or of its absence, against the app hash of a header certified by the light client.
+// A missing key gives a nil value.
+func queryProven(cliCtx context.CLIContext, key []byte) ([]byte, error) {
+	if cliCtx.Verifier == nil {
+		return nil, fmt.Errorf(
+			"Can't verify proofs without a light client, pass --trust-node=false")
+	}
+	node, err := cliCtx.GetNode()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := node.ABCIQueryWithOptions(fmt.Sprintf("/store/%s/key", gitService.StoreKey),
+		key, rpcclient.ABCIQueryOptions{Height: cliCtx.Height, Prove: true})
+	if err != nil {
+		return nil, err
+	}
+	resp := result.Response
+	if !resp.IsOK() {
+		return nil, errors.New(resp.Log)
+	}
+	if cliCtx.Height > 0 && resp.Height != cliCtx.Height {
+		return nil, fmt.Errorf("Node answered query of '%s' at height %d rather than %d", key,
+			resp.Height, cliCtx.Height)
+	}
+
+	// The app hash of the state at a height is in the header of the next block
+	commit, err := cliCtx.Verify(resp.Height + 1)
+	if err != nil {
+		return nil, err
+	}
+	keyPath := merkle.KeyPath{}.AppendKey([]byte(gitService.StoreKey), merkle.KeyEncodingURL).
+		AppendKey(key, merkle.KeyEncodingURL).String()
+	prt := store.DefaultProofRuntime()
+	if resp.Value == nil {
+		err = prt.VerifyAbsence(resp.Proof, commit.Header.AppHash, keyPath)
+	} else {
+		err = prt.VerifyValue(resp.Proof, commit.Header.AppHash, keyPath, resp.Value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Proof of '%s' at height %d doesn't verify: %s", key,
+			resp.Height, err)
+	}
+
+	log.Debug().Msgf("Verified proof of '%s' at height %d", key, resp.Height)
+	return resp.Value, nil
+}
+
+// refVerifier verifies what an untrusted node lists for the references of a repository
+// against proven values from the git store
+type refVerifier struct {
+	cliCtx context.CLIContext
+	uri    string
+	// values caches the proven values of store keys
+	values map[string][]byte
+	// listed holds the references verified as listed
+	listed map[plumbing.ReferenceName]bool
+}
+
+func newRefVerifier(cliCtx context.CLIContext, uri string) *refVerifier {
+	return &refVerifier{
+		cliCtx: cliCtx,
+		uri:    uri,
+		values: map[string][]byte{},
+		listed: map[plumbing.ReferenceName]bool{},
+	}
+}
+
+// value gets the proven value of a store key, nil if it doesn't exist
+func (v *refVerifier) value(key []byte) ([]byte, error) {
+	if value, ok := v.values[string(key)]; ok {
+		return value, nil
+	}
+
+	value, err := queryProven(v.cliCtx, key)
+	if err != nil {
+		return nil, err
+	}
+	v.values[string(key)] = value
+	return value, nil
+}
+
+// resolve resolves a reference to a hash through proven values, following symbolic
+// references like the server does. The zero hash means that the reference is unborn.
+func (v *refVerifier) resolve(name plumbing.ReferenceName) (plumbing.Hash, error) {
+	for i := 0; i <= gitService.MaxSymrefDepth; i++ {
+		value, err := v.value(gitService.RefKey(v.uri, name))
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if value == nil {
+			break
+		}
+
+		ref := plumbing.NewReferenceFromStrings(name.String(), string(value))
+		if ref.Type() == plumbing.HashReference {
+			return ref.Hash(), nil
+		}
+		name = ref.Target()
+	}
+
+	return plumbing.ZeroHash, nil
+}
+
+// verifyHash checks that a reference resolves to the hash listed for it
+func (v *refVerifier) verifyHash(name plumbing.ReferenceName, h plumbing.Hash) error {
+	proven, err := v.resolve(name)
+	if err != nil {
+		return err
+	}
+	if proven != h {
+		return fmt.Errorf("Node listed %s for reference '%s' of %s, but %s is proven", h, name,
+			v.uri, proven)
+	}
+
+	v.listed[name] = true
+	return nil
+}
+
+// verifySymref checks that a symbolic reference points to the target listed for it
+func (v *refVerifier) verifySymref(name plumbing.ReferenceName,
+	target plumbing.ReferenceName) error {
+	value, err := v.value(gitService.RefKey(v.uri, name))
+	if err != nil {
+		return err
+	}
+	if string(value) != fmt.Sprintf("ref: %s", target) {
+		return fmt.Errorf(
+			"Node listed target '%s' for reference '%s' of %s, but '%s' is proven", target,
+			name, v.uri, value)
+	}
+
+	v.listed[name] = true
+	return nil
+}
+
+// verifyOmitted checks that the references a complete listing for a query left out don't
+// exist, or are unborn unless symbolic references are listed by target. The references
+// checked are HEAD, unless the query's prefixes exclude it, and those named exactly by the
+// prefixes, as Git passes the sources of refspecs as prefixes. Whether the listing holds
+// every other reference matching the prefixes can't be proven.
+func (v *refVerifier) verifyOmitted(query gitService.RefsQuery) error {
+	var names []plumbing.ReferenceName
+	if gitService.MatchesRefPrefixes(plumbing.HEAD.String(), query.RefPrefixes) {
+		names = append(names, plumbing.HEAD)
+	}
+	for _, prefix := range query.RefPrefixes {
+		if strings.HasPrefix(prefix, "refs/") && !strings.HasSuffix(prefix, "/") {
+			names = append(names, plumbing.ReferenceName(prefix))
+		}
+	}
+
+	for _, name := range names {
+		if v.listed[name] {
+			continue
+		}
+
+		if query.Symrefs {
+			value, err := v.value(gitService.RefKey(v.uri, name))
+			if err != nil {
+				return err
+			}
+			if value != nil {
+				return fmt.Errorf("Node omitted reference '%s' of %s, but '%s' is proven",
+					name, v.uri, value)
+			}
+			continue
+		}
+
+		proven, err := v.resolve(name)
+		if err != nil {
+			return err
+		}
+		if !proven.IsZero() {
+			return fmt.Errorf("Node omitted reference '%s' of %s, but %s is proven", name,
+				v.uri, proven)
+		}
+	}
+
+	return nil
+}
+
+// verifyPeeled checks the peeled value listed for a tag against the one stored when the tag got
+// pushed. It returns false if there's none stored, in which case the value can't be proven.
+func (v *refVerifier) verifyPeeled(name plumbing.ReferenceName, h plumbing.Hash) (bool,
+	error) {
+	value, err := v.value(gitService.PeeledKey(v.uri, name))
+	if err != nil || value == nil {
+		return false, err
+	}
+	if proven := plumbing.NewHash(string(value)); proven != h {
+		return false, fmt.Errorf(
+			"Node listed peeled value %s for tag '%s' of %s, but %s is proven", h, name, v.uri,
+			proven)
+	}
+
+	return true, nil
+}
+
+// verifyLine checks a reference listed on the format of the Git remote helper list command,
+// returning the line without peeled values that can't be proven
+func (v *refVerifier) verifyLine(line string) (string, error) {
+	parts := strings.Split(line, " ")
+	if len(parts) < 2 {
+		return "", fmt.Errorf("Malformed reference: '%s'", line)
+	}
+
+	name := plumbing.ReferenceName(parts[1])
+	if strings.HasPrefix(parts[0], "@") {
+		if err := v.verifySymref(name, plumbing.ReferenceName(parts[0][1:])); err != nil {
+			return "", err
+		}
+	} else if err := v.verifyHash(name, plumbing.NewHash(parts[0])); err != nil {
+		return "", err
+	}
+
+	verified := []string{parts[0], parts[1]}
+	for _, attr := range parts[2:] {
+		if strings.HasPrefix(attr, "peeled:") {
+			ok, err := v.verifyPeeled(name, plumbing.NewHash(attr[len("peeled:"):]))
+			if err != nil {
+				return "", err
+			}
+			if !ok {
+				log.Debug().Msgf("Dropping unprovable peeled value of '%s'", name)
+				continue
+			}
+		}
+
+		verified = append(verified, attr)
+	}
+
+	return strings.Join(verified, " "), nil
+}
+
+// verifyAdvRefs checks the advertised references of all pages for a query, dropping peeled
+// values that can't be proven
+func (v *refVerifier) verifyAdvRefs(ar *packp.AdvRefs, query gitService.RefsQuery) error {
+	if ar.Head != nil {
+		if err := v.verifyHash(plumbing.HEAD, *ar.Head); err != nil {
+			return err
+		}
+	}
+	for name, h := range ar.References {
+		if err := v.verifyHash(plumbing.ReferenceName(name), h); err != nil {
+			return err
+		}
+	}
+	// Symbolic references are advertised by what they resolve to
+	query.Symrefs = false
+	if err := v.verifyOmitted(query); err != nil {
+		return err
+	}
+	for name, h := range ar.Peeled {
+		ok, err := v.verifyPeeled(plumbing.ReferenceName(name), h)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			delete(ar.Peeled, name)
+		}
+	}
+
+	return nil
+}
+
+// keyExistsAt says whether a key of the git store exists at a height, failing if the node
+// doesn't have the state at the height
+func keyExistsAt(node rpcclient.Client, key []byte, height int64) (bool, error) {
+	result, err := node.ABCIQueryWithOptions(fmt.Sprintf("/store/%s/key", gitService.StoreKey),
+		key, rpcclient.ABCIQueryOptions{Height: height})
+	if err != nil {
+		return false, err
+	}
+	resp := result.Response
+	if !resp.IsOK() || resp.Log != "" {
+		return false, fmt.Errorf("State at height %d isn't available: %s", height, resp.Log)
+	}
+
+	return resp.Value != nil, nil
+}
+
+// removalHeights finds the heights at which a repository got removed up to a height, in
+// ascending order, through the transactions indexed by the node
+func removalHeights(node rpcclient.Client, uri string, height int64) ([]int64, error) {
+	query := fmt.Sprintf("%s='%s' AND action='%s' AND tx.height<=%d", gitService.TagRepo, uri,
+		gitService.MsgRemoveRepository{}.Type(), height)
+	seen := map[int64]bool{}
+	var heights []int64
+	for page, count := 1, 0; ; page++ {
+		res, err := node.TxSearch(query, false, page, txSearchPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range res.Txs {
+			if !seen[tx.Height] {
+				seen[tx.Height] = true
+				heights = append(heights, tx.Height)
+			}
+		}
+		count += len(res.Txs)
+		if len(res.Txs) == 0 || count >= res.TotalCount {
+			break
+		}
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+
+	return heights, nil
+}
+
+// firstHeight finds the first height a key of a repository's data exists at, given that it
+// exists at a height. Such keys only get deleted by removing the repository, so between
+// removals a key exists from some height on, which gets found by binary search within the
+// earliest stretch between removals that ends with the key existing.
+func firstHeight(node rpcclient.Client, uri string, key []byte, height int64) (int64, error) {
+	removals, err := removalHeights(node, uri, height)
+	if err != nil {
+		return 0, err
+	}
+	log.Debug().Msgf("Repo '%s' got removed at heights %v", uri, removals)
+
+	// A removal and a push in the same block may leave the key existing at the removal height
+	starts := append([]int64{1}, removals...)
+	for i, lo := range starts {
+		hi := height
+		if i+1 < len(starts) {
+			hi = starts[i+1] - 1
+		}
+		if hi < lo {
+			continue
+		}
+		exists, err := keyExistsAt(node, key, hi)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			continue
+		}
+
+		for lo < hi {
+			mid := lo + (hi-lo)/2
+			exists, err := keyExistsAt(node, key, mid)
+			if err != nil {
+				return 0, err
+			}
+			if exists {
+				hi = mid
+			} else {
+				lo = mid + 1
+			}
+		}
+		return lo, nil
+	}
+
+	return 0, fmt.Errorf("Key %s doesn't exist at height %d", key, height)
+}
+
+// proveCommit builds the proof that an object was stored in a repository, at the first height
+// one of the packfiles holding it at a height got stored at
+func proveCommit(cliCtx context.CLIContext, moduleName string, uri string, h plumbing.Hash,
+	height int64) (*gitService.CommitProof, error) {
+	node, err := cliCtx.GetNode()
+	if err != nil {
+		return nil, err
+	}
+
+	cliCtx.Height = height
+	res, err := cliCtx.QueryWithData(fmt.Sprintf("custom/%s/objectPacks/%s/%s", moduleName, uri,
+		h), nil)
+	if err != nil {
+		return nil, err
+	}
+	var resp gitService.ObjectPacksResponse
+	if err := encJson.Unmarshal(res, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Packs) == 0 {
+		return nil, fmt.Errorf("Object %s isn't stored in %s at height %d", h, uri, height)
+	}
+
+	var pack plumbing.Hash
+	first := height
+	for _, p := range resp.Packs {
+		packHash := plumbing.NewHash(p)
+		packHeight, err := firstHeight(node, uri, gitService.IndexKey(uri, packHash), height)
+		if err != nil {
+			return nil, err
+		}
+		log.Debug().Msgf("Packfile %s first got stored at height %d", p, packHeight)
+		if pack.IsZero() || packHeight < first {
+			pack, first = packHash, packHeight
+		}
+	}
+
+	key := gitService.IndexKey(uri, pack)
+	result, err := node.ABCIQueryWithOptions(fmt.Sprintf("/store/%s/key", gitService.StoreKey),
+		key, rpcclient.ABCIQueryOptions{Height: first, Prove: true})
+	if err != nil {
+		return nil, err
+	}
+	if !result.Response.IsOK() || result.Response.Value == nil {
+		return nil, fmt.Errorf("Couldn't query index of packfile %s at height %d: %s", pack,
+			first, result.Response.Log)
+	}
+
+	// The app hash of the state at a height is in the header of the next block
+	next := first + 1
+	commit, err := node.Commit(&next)
+	if err != nil {
+		return nil, err
+	}
+	validators, err := node.Validators(&next)
+	if err != nil {
+		return nil, err
+	}
+	if cliCtx.Verifier != nil {
+		certified, err := cliCtx.Verify(next)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(certified.Hash(), commit.SignedHeader.Hash()) {
+			return nil, fmt.Errorf(
+				"Header at height %d isn't the one certified by the light client", next)
+		}
+	}
+
+	return &gitService.CommitProof{
+		ChainID:      commit.SignedHeader.ChainID,
+		URI:          uri,
+		Object:       h.String(),
+		Pack:         pack.String(),
+		Height:       first,
+		Index:        result.Response.Value,
+		Proof:        result.Response.Proof,
+		SignedHeader: commit.SignedHeader,
+		Validators:   validators.Validators,
+	}, nil
+}
+
+// GetCmdProveCommit returns Cobra command for proving that a commit was stored in a repository
+func GetCmdProveCommit(moduleName string, cdc *codec.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "prove-commit URI hash",
+		Short: "Prove that a commit was stored in a repository, and from which height",
+		Long: `Prove that a commit, or any other object, was stored in a repository, and from
+which height. The proof gets written to stdout as JSON, and consists of the index of the
+packfile holding the object, its merkle proof against the app hash of the next block and the
+header of that block signed by the validators. It can be verified offline with
+verify-commit-proof. Finding the first height requires the node to have kept the states since,
+and to index the repo and action tags, to account for removals of the repository.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			uri := args[0]
+			h := plumbing.NewHash(args[1])
+			if h.String() != strings.ToLower(args[1]) {
+				return fmt.Errorf("Invalid object hash: '%s'", args[1])
+			}
+
+			cliCtx := context.NewCLIContext().WithCodec(cdc)
+			height := cliCtx.Height
+			if height <= 0 {
+				node, err := cliCtx.GetNode()
+				if err != nil {
+					return err
+				}
+				status, err := node.Status()
+				if err != nil {
+					return err
+				}
+				height = status.SyncInfo.LatestBlockHeight - 1
+			}
+
+			proof, err := proveCommit(cliCtx, moduleName, uri, h, height)
+			if err != nil {
+				return err
+			}
+			if err := proof.Verify(); err != nil {
+				return fmt.Errorf("Proof doesn't verify: %s", err)
+			}
+
+			out, err := cdc.MarshalJSONIndent(proof, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+			fmt.Fprintf(os.Stderr,
+				"Object %s stored in %s since height %d, in packfile %s (validators hash %X)\n",
+				h, uri, proof.Height, proof.Pack, proof.ValidatorsHash())
+
+			return nil
+		},
+	}
+	return cmd
+}
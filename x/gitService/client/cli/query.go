@@ -3,16 +3,79 @@ package cli
 import (
 	encJson "encoding/json"
 	"fmt"
+	"os"
+	"time"
 
 	"github.com/cosmos/cosmos-sdk/client/context"
 	"github.com/cosmos/cosmos-sdk/codec"
+	"github.com/joystream/onchain-git-poc/x/gitService"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/src-d/go-git.v4/plumbing"
 )
 
+const (
+	flagRefPrefix = "ref-prefix"
+	flagPeel      = "peel"
+	flagSymrefs   = "symrefs"
+	flagStart     = "start"
+	flagLimit     = "limit"
+)
+
+// queryRefLines queries the references of a repository matching a query, on the format of the
+// Git remote helper list command. If the query has no limit, all pages get fetched, otherwise
+// the cursor for the next page is returned as well. The references get verified against
+// proofs unless the node is trusted, including that a complete listing doesn't omit HEAD or
+// references named by the query's prefixes.
+func queryRefLines(cliCtx context.CLIContext, moduleName string, uri string,
+	query gitService.RefsQuery) ([]string, string, error) {
+	var verifier *refVerifier
+	if !cliCtx.TrustNode {
+		verifier = newRefVerifier(cliCtx, uri)
+	}
+	complete := query.Start == "" && query.Limit <= 0
+	var lines []string
+	for {
+		data, err := encJson.Marshal(query)
+		if err != nil {
+			return nil, "", err
+		}
+		res, err := cliCtx.QueryWithData(fmt.Sprintf("custom/%s/listRefs/%s", moduleName, uri),
+			data)
+		if err != nil {
+			return nil, "", err
+		}
+
+		var resp gitService.ListRefsResponse
+		if err := encJson.Unmarshal(res, &resp); err != nil {
+			return nil, "", err
+		}
+		log.Debug().Msgf("Received refs: %v, next: '%s'", resp.Refs, resp.Next)
+		for _, line := range resp.Refs {
+			if verifier != nil {
+				if line, err = verifier.verifyLine(line); err != nil {
+					return nil, "", err
+				}
+			}
+			lines = append(lines, line)
+		}
+		if resp.Next == "" && complete && verifier != nil {
+			if err := verifier.verifyOmitted(query); err != nil {
+				return nil, "", err
+			}
+		}
+		if resp.Next == "" || query.Limit > 0 {
+			return lines, resp.Next, nil
+		}
+
+		query.Start = resp.Next
+	}
+}
+
 // GetCmdListRefs returns Cobra command for listing Git references
 func GetCmdListRefs(moduleName string, cdc *codec.Codec) *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "list URI",
 		Short: "List Git references in repository",
 		Args:  cobra.ExactArgs(1),
@@ -20,21 +83,377 @@ func GetCmdListRefs(moduleName string, cdc *codec.Codec) *cobra.Command {
 			uri := args[0]
 			log.Debug().Msgf("Listing references of repo %v", uri)
 
+			// Viper would mangle the string array
+			refPrefixes, err := cmd.Flags().GetStringArray(flagRefPrefix)
+			if err != nil {
+				return err
+			}
+
+			cliCtx, err := pinProvableHeight(context.NewCLIContext().WithCodec(cdc))
+			if err != nil {
+				return err
+			}
+			query := gitService.RefsQuery{
+				RefPrefixes: refPrefixes,
+				Peel:        viper.GetBool(flagPeel),
+				Symrefs:     viper.GetBool(flagSymrefs),
+				Start:       viper.GetString(flagStart),
+				Limit:       viper.GetInt(flagLimit),
+			}
+			refs, next, err := queryRefLines(cliCtx, moduleName, uri, query)
+			if err != nil {
+				return err
+			}
+
+			for _, ref := range refs {
+				fmt.Println(ref)
+			}
+			if next != "" {
+				fmt.Fprintf(os.Stderr, "More references follow, continue with --%s %s\n",
+					flagStart, next)
+			}
+
+			return nil
+		},
+	}
+	cmd.Flags().StringArray(flagRefPrefix, nil,
+		"Only list references starting with prefix (can be repeated)")
+	cmd.Flags().Bool(flagPeel, false, "Include what annotated tags point to")
+	cmd.Flags().Bool(flagSymrefs, true,
+		"List symbolic references by their target rather than their hash")
+	cmd.Flags().String(flagStart, "", "Continue listing after this reference")
+	cmd.Flags().Int(flagLimit, 0, fmt.Sprintf(
+		"Only list a single page of at most this many references (max %d), 0 lists all",
+		gitService.MaxRefsPageSize))
+
+	return cmd
+}
+
+// GetCmdRepository returns Cobra command for showing the metadata of a repository
+func GetCmdRepository(moduleName string, cdc *codec.Codec) *cobra.Command {
+	return &cobra.Command{
+		Use:   "repository URI",
+		Short: "Show the metadata of a repository",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			uri := args[0]
+			log.Debug().Msgf("Querying metadata of repo %v", uri)
+
+			cliCtx := context.NewCLIContext().WithCodec(cdc)
+			res, err := cliCtx.QueryWithData(fmt.Sprintf("custom/%s/repository/%s", moduleName,
+				uri), nil)
+			if err != nil {
+				return err
+			}
+
+			var repo gitService.Repository
+			if err := encJson.Unmarshal(res, &repo); err != nil {
+				return err
+			}
+			out, err := encJson.MarshalIndent(repo, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+
+			return nil
+		},
+	}
+}
+
+// GetCmdStorage returns Cobra command for showing the storage usage and deposits of a
+// repository
+func GetCmdStorage(moduleName string, cdc *codec.Codec) *cobra.Command {
+	return &cobra.Command{
+		Use:   "storage URI",
+		Short: "Show the storage usage and storage deposits of a repository",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			uri := args[0]
+			log.Debug().Msgf("Querying storage of repo %v", uri)
+
+			cliCtx := context.NewCLIContext().WithCodec(cdc)
+			res, err := cliCtx.QueryWithData(fmt.Sprintf("custom/%s/storage/%s", moduleName,
+				uri), nil)
+			if err != nil {
+				return err
+			}
+
+			var resp gitService.StorageResponse
+			if err := encJson.Unmarshal(res, &resp); err != nil {
+				return err
+			}
+			out, err := encJson.MarshalIndent(resp, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+
+			return nil
+		},
+	}
+}
+
+// GetCmdQuotas returns Cobra command for showing the quotas of a repository and its usage
+func GetCmdQuotas(moduleName string, cdc *codec.Codec) *cobra.Command {
+	return &cobra.Command{
+		Use:   "quotas URI",
+		Short: "Show the quotas applying to a repository and its usage against them",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			uri := args[0]
+			log.Debug().Msgf("Querying quotas of repo %v", uri)
+
+			cliCtx := context.NewCLIContext().WithCodec(cdc)
+			res, err := cliCtx.QueryWithData(fmt.Sprintf("custom/%s/quotas/%s", moduleName,
+				uri), nil)
+			if err != nil {
+				return err
+			}
+
+			var resp gitService.QuotasResponse
+			if err := encJson.Unmarshal(res, &resp); err != nil {
+				return err
+			}
+			out, err := encJson.MarshalIndent(resp, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+
+			return nil
+		},
+	}
+}
+
+// GetCmdParams returns Cobra command for showing the gitService module parameters
+func GetCmdParams(moduleName string, cdc *codec.Codec) *cobra.Command {
+	return &cobra.Command{
+		Use:   "params",
+		Short: "Show the gitService module parameters",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log.Debug().Msgf("Querying module parameters")
 			cliCtx := context.NewCLIContext().WithCodec(cdc)
-			res, err := cliCtx.QueryWithData(fmt.Sprintf("custom/%s/listRefs/%s", moduleName, uri), nil)
+			res, err := cliCtx.QueryWithData(fmt.Sprintf("custom/%s/params", moduleName), nil)
+			if err != nil {
+				return err
+			}
+
+			var params gitService.Params
+			if err := encJson.Unmarshal(res, &params); err != nil {
+				return err
+			}
+			out, err := encJson.MarshalIndent(params, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+
+			return nil
+		},
+	}
+}
+
+// GetCmdListRepos returns Cobra command for listing repositories
+func GetCmdListRepos(moduleName string, cdc *codec.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "repos [owner]",
+		Short: "List repositories, optionally only those of an owner",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query := gitService.ReposQuery{
+				Start: viper.GetString(flagStart),
+				Limit: viper.GetInt(flagLimit),
+			}
+			if len(args) > 0 {
+				query.Owner = args[0]
+			}
+			log.Debug().Msgf("Listing repos, query: %+v", query)
+
+			cliCtx := context.NewCLIContext().WithCodec(cdc)
+			var repos []gitService.RepositorySummary
+			var next string
+			for {
+				data, err := encJson.Marshal(query)
+				if err != nil {
+					return err
+				}
+				res, err := cliCtx.QueryWithData(fmt.Sprintf("custom/%s/repos", moduleName), data)
+				if err != nil {
+					return err
+				}
+
+				var resp gitService.ListReposResponse
+				if err := encJson.Unmarshal(res, &resp); err != nil {
+					return err
+				}
+				repos = append(repos, resp.Repositories...)
+				next = resp.Next
+				if next == "" || query.Limit > 0 {
+					break
+				}
+
+				query.Start = resp.Next
+			}
+
+			if repos == nil {
+				repos = []gitService.RepositorySummary{}
+			}
+			out, err := encJson.MarshalIndent(repos, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+			if next != "" {
+				fmt.Fprintf(os.Stderr, "More repositories follow, continue with --%s %s\n",
+					flagStart, next)
+			}
+
+			return nil
+		},
+	}
+	cmd.Flags().String(flagStart, "", "Continue listing after this repository")
+	cmd.Flags().Int(flagLimit, 0, fmt.Sprintf(
+		"Only list a single page of at most this many repositories (max %d), 0 lists all",
+		gitService.MaxReposPageSize))
+
+	return cmd
+}
+
+// GetCmdSearch returns Cobra command for searching repositories
+func GetCmdSearch(moduleName string, cdc *codec.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "search [text]",
+		Short: "Search public repositories by owner, name, description and topics",
+		Long: `Search public repositories by owner, name, description and topics. Repositories must
+contain all words of the text in their owner, name or description, case insensitively, and have
+all of the topics. The most recently active repositories are listed first.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Viper would mangle the string array
+			topics, err := cmd.Flags().GetStringArray(flagTopic)
 			if err != nil {
 				return err
 			}
+			query := gitService.SearchQuery{
+				Topics: topics,
+				Limit:  viper.GetInt(flagLimit),
+			}
+			if len(args) > 0 {
+				query.Text = args[0]
+			}
+			log.Debug().Msgf("Searching repos, query: %+v", query)
 
-			var refs []string
-			if err := encJson.Unmarshal(res, &refs); err != nil {
+			data, err := encJson.Marshal(query)
+			if err != nil {
+				return err
+			}
+			cliCtx := context.NewCLIContext().WithCodec(cdc)
+			res, err := cliCtx.QueryWithData(fmt.Sprintf("custom/%s/search", moduleName), data)
+			if err != nil {
 				return err
 			}
 
-			log.Debug().Msgf("Received refs: %v", refs)
-			fmt.Printf("\n")
+			var results []gitService.SearchResult
+			if err := encJson.Unmarshal(res, &results); err != nil {
+				return err
+			}
+			out, err := encJson.MarshalIndent(results, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
 
 			return nil
 		},
 	}
+	cmd.Flags().StringArray(flagTopic, nil, "Topic repositories must have (can be repeated)")
+	cmd.Flags().Int(flagLimit, 0, fmt.Sprintf(
+		"Maximum number of repositories to list (max %d), 0 lists the maximum",
+		gitService.MaxSearchResults))
+
+	return cmd
+}
+
+// queryReflog queries a page of the log of a reference
+func queryReflog(cliCtx context.CLIContext, moduleName string, uri string,
+	name plumbing.ReferenceName, query gitService.ReflogQuery) (*gitService.ReflogResponse,
+	error) {
+	data, err := encJson.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+	res, err := cliCtx.QueryWithData(fmt.Sprintf("custom/%s/reflog/%s/%s", moduleName, uri,
+		name), data)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp gitService.ReflogResponse
+	if err := encJson.Unmarshal(res, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// formatReflogEntry formats a reflog entry for display, on the format
+// "<ref>@{<index>} <old> -> <new> <author> <height> <time> <tx hash>"
+func formatReflogEntry(name plumbing.ReferenceName, entry gitService.ReflogEntry) string {
+	return fmt.Sprintf("%s@{%d} %s -> %s %s %d %s %s", name.Short(), entry.Index, entry.Old,
+		entry.New, entry.Author, entry.Height, entry.Time.UTC().Format(time.RFC3339),
+		entry.TxHash)
+}
+
+// GetCmdReflog returns Cobra command for listing the log of a reference
+func GetCmdReflog(moduleName string, cdc *codec.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reflog URI ref",
+		Short: "List the changes of a reference, the newest first",
+		Long: `List the changes of a reference, the newest first. Each line holds the index of the
+change, the old and new hashes, the author, the block height and time and the transaction hash.
+Branch names may be given in short form, e.g. main.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			uri := args[0]
+			name := branchName(args[1])
+			before, err := cmd.Flags().GetUint64(flagStart)
+			if err != nil {
+				return err
+			}
+			query := gitService.ReflogQuery{
+				Before: before,
+				Limit:  viper.GetInt(flagLimit),
+			}
+			log.Debug().Msgf("Listing log of reference %s in repo %s", name, uri)
+
+			cliCtx := context.NewCLIContext().WithCodec(cdc)
+			for {
+				resp, err := queryReflog(cliCtx, moduleName, uri, name, query)
+				if err != nil {
+					return err
+				}
+
+				for _, entry := range resp.Entries {
+					fmt.Println(formatReflogEntry(name, entry))
+				}
+				if resp.Next != 0 && query.Limit > 0 {
+					fmt.Fprintf(os.Stderr, "More entries follow, continue with --%s %d\n",
+						flagStart, resp.Next)
+				}
+				if resp.Next == 0 || query.Limit > 0 {
+					return nil
+				}
+
+				query.Before = resp.Next
+			}
+		},
+	}
+	cmd.Flags().Uint64(flagStart, 0, "Continue listing before the entry with this index")
+	cmd.Flags().Int(flagLimit, 0, fmt.Sprintf(
+		"Only list a single page of at most this many entries (max %d), 0 lists all",
+		gitService.MaxReflogPageSize))
+
+	return cmd
 }
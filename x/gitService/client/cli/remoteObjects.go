@@ -0,0 +1,385 @@
+package cli
+
+import (
+	"bytes"
+	"container/heap"
+	encJson "encoding/json"
+	"fmt"
+
+	"github.com/cosmos/cosmos-sdk/client/context"
+	"github.com/joystream/onchain-git-poc/x/gitService"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+// remoteObjectStorage is an object storage of a repository on the blockchain, that loads
+// packfiles into memory on demand, the first time one of their objects is read. Stored packfiles
+// are self-contained, so any packfile holding an object suffices for reading it.
+type remoteObjectStorage struct {
+	*memory.Storage
+	cliCtx     context.CLIContext
+	moduleName string
+	uri        string
+	loaded     map[string]bool
+	// stored caches whether objects not loaded are stored, as found through the node
+	stored map[plumbing.Hash]bool
+}
+
+func newRemoteObjectStorage(cliCtx context.CLIContext, moduleName string,
+	uri string) *remoteObjectStorage {
+	return &remoteObjectStorage{
+		Storage:    memory.NewStorage(),
+		cliCtx:     cliCtx,
+		moduleName: moduleName,
+		uri:        uri,
+		loaded:     make(map[string]bool),
+		stored:     make(map[plumbing.Hash]bool),
+	}
+}
+
+// objectPacks queries the packfiles holding an object
+func (s *remoteObjectStorage) objectPacks(h plumbing.Hash) ([]string, error) {
+	res, err := s.cliCtx.QueryWithData(fmt.Sprintf("custom/%s/objectPacks/%s/%s", s.moduleName,
+		s.uri, h), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp gitService.ObjectPacksResponse
+	if err := encJson.Unmarshal(res, &resp); err != nil {
+		return nil, err
+	}
+	s.stored[h] = len(resp.Packs) > 0
+	return resp.Packs, nil
+}
+
+// loadObject loads a packfile holding an object into memory, unless one already is
+func (s *remoteObjectStorage) loadObject(h plumbing.Hash) error {
+	packs, err := s.objectPacks(h)
+	if err != nil {
+		return err
+	}
+	for _, pack := range packs {
+		if s.loaded[pack] {
+			return nil
+		}
+	}
+	if len(packs) == 0 {
+		return plumbing.ErrObjectNotFound
+	}
+
+	log.Debug().Msgf("Loading packfile %s of repo '%s', holding object %s", packs[0], s.uri, h)
+	packBytes, err := s.cliCtx.QueryWithData(fmt.Sprintf("custom/%s/packfile/%s/%s",
+		s.moduleName, s.uri, packs[0]), nil)
+	if err != nil {
+		return err
+	}
+	if err := packfile.UpdateObjectStorage(s.Storage, bytes.NewReader(packBytes)); err != nil {
+		return err
+	}
+	s.loaded[packs[0]] = true
+
+	return nil
+}
+
+// EncodedObject gets an object, loading a packfile holding it if not already loaded
+func (s *remoteObjectStorage) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (
+	plumbing.EncodedObject, error) {
+	obj, err := s.Storage.EncodedObject(t, h)
+	if err != plumbing.ErrObjectNotFound {
+		return obj, err
+	}
+
+	if err := s.loadObject(h); err != nil {
+		return nil, err
+	}
+	return s.Storage.EncodedObject(t, h)
+}
+
+// HasEncodedObject says whether an object is stored, without loading it
+func (s *remoteObjectStorage) HasEncodedObject(h plumbing.Hash) error {
+	if s.Storage.HasEncodedObject(h) == nil {
+		return nil
+	}
+
+	stored, ok := s.stored[h]
+	if !ok {
+		if _, err := s.objectPacks(h); err != nil {
+			return err
+		}
+		stored = s.stored[h]
+	}
+	if !stored {
+		return plumbing.ErrObjectNotFound
+	}
+	return nil
+}
+
+// EncodedObjectSize gets the size of an object, loading a packfile holding it if not already
+// loaded
+func (s *remoteObjectStorage) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	obj, err := s.EncodedObject(plumbing.AnyObject, h)
+	if err != nil {
+		return 0, err
+	}
+	return obj.Size(), nil
+}
+
+// commitQueue is a priority queue of commits, the most recently committed first
+type commitQueue []*object.Commit
+
+func (q commitQueue) Len() int {
+	return len(q)
+}
+
+func (q commitQueue) Less(i, j int) bool {
+	return q[i].Committer.When.After(q[j].Committer.When)
+}
+
+func (q commitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *commitQueue) Push(x interface{}) {
+	*q = append(*q, x.(*object.Commit))
+}
+
+func (q *commitQueue) Pop() interface{} {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// fetchWalker determines the objects to send for a fetch, like git rev-list --objects
+type fetchWalker struct {
+	storage *remoteObjectStorage
+	// uninteresting holds the commits the client has, i.e. the common haves and their ancestors
+	uninteresting map[plumbing.Hash]bool
+	seen          map[plumbing.Hash]bool
+	commits       []plumbing.Hash
+	objects       []plumbing.Hash
+	queue         commitQueue
+}
+
+// enqueue adds a commit to the walk, unless already added
+func (w *fetchWalker) enqueue(h plumbing.Hash) error {
+	if w.seen[h] {
+		return nil
+	}
+
+	commit, err := object.GetCommit(w.storage, h)
+	if err != nil {
+		return err
+	}
+	w.seen[h] = true
+	heap.Push(&w.queue, commit)
+	return nil
+}
+
+// onlyUninteresting says whether all queued commits are ones the client has, in which case
+// walking further can't find any commits to send
+func (w *fetchWalker) onlyUninteresting() bool {
+	for _, c := range w.queue {
+		if !w.uninteresting[c.Hash] {
+			return false
+		}
+	}
+	return true
+}
+
+// walkCommits walks the commits reachable from the queued ones in commit time order, stopping
+// once only commits the client has remain queued
+func (w *fetchWalker) walkCommits() error {
+	for !w.onlyUninteresting() {
+		commit := heap.Pop(&w.queue).(*object.Commit)
+		uninteresting := w.uninteresting[commit.Hash]
+		if !uninteresting {
+			w.commits = append(w.commits, commit.Hash)
+		}
+		for _, parent := range commit.ParentHashes {
+			if uninteresting {
+				w.uninteresting[parent] = true
+			}
+			if err := w.enqueue(parent); err != nil {
+				if uninteresting && err == plumbing.ErrObjectNotFound {
+					// The history the client has may be shallow
+					continue
+				}
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// walkTree adds the objects of a tree that differ from the trees at the same path in the
+// commits the client has, which the client then has the objects of
+func (w *fetchWalker) walkTree(h plumbing.Hash, bases []plumbing.Hash) error {
+	if w.seen[h] {
+		return nil
+	}
+	for _, base := range bases {
+		if base == h {
+			return nil
+		}
+	}
+	w.seen[h] = true
+	w.objects = append(w.objects, h)
+
+	tree, err := object.GetTree(w.storage, h)
+	if err != nil {
+		return err
+	}
+	baseEntries := make([]map[string]object.TreeEntry, 0, len(bases))
+	for _, base := range bases {
+		baseTree, err := object.GetTree(w.storage, base)
+		if err != nil {
+			return err
+		}
+		entries := make(map[string]object.TreeEntry, len(baseTree.Entries))
+		for _, entry := range baseTree.Entries {
+			entries[entry.Name] = entry
+		}
+		baseEntries = append(baseEntries, entries)
+	}
+
+	for _, entry := range tree.Entries {
+		switch entry.Mode {
+		case filemode.Submodule:
+			continue
+		case filemode.Dir:
+			var subBases []plumbing.Hash
+			for _, entries := range baseEntries {
+				if baseEntry, ok := entries[entry.Name]; ok && baseEntry.Mode == filemode.Dir {
+					subBases = append(subBases, baseEntry.Hash)
+				}
+			}
+			if err := w.walkTree(entry.Hash, subBases); err != nil {
+				return err
+			}
+		default:
+			unchanged := false
+			for _, entries := range baseEntries {
+				if baseEntry, ok := entries[entry.Name]; ok && baseEntry.Hash == entry.Hash {
+					unchanged = true
+					break
+				}
+			}
+			if !unchanged && !w.seen[entry.Hash] {
+				w.seen[entry.Hash] = true
+				w.objects = append(w.objects, entry.Hash)
+			}
+		}
+	}
+
+	return nil
+}
+
+// fetchObjects determines the objects reachable from wants that aren't reachable from the
+// common haves. Rather than walking all of the history of the haves, like revlist.Objects does,
+// the commits get walked only down to the haves, and trees only where they differ from the trees
+// of the haves, so that only the packfiles holding the objects to send get loaded.
+func fetchObjects(storage *remoteObjectStorage, wants []plumbing.Hash,
+	common []plumbing.Hash) ([]plumbing.Hash, error) {
+	w := &fetchWalker{
+		storage:       storage,
+		uninteresting: make(map[plumbing.Hash]bool),
+		seen:          make(map[plumbing.Hash]bool),
+	}
+	for _, h := range common {
+		w.uninteresting[h] = true
+		if err := w.enqueue(h); err != nil && err != object.ErrUnsupportedObject {
+			// Haves that aren't commits don't bound the walk
+			return nil, err
+		}
+	}
+
+	var trees []plumbing.Hash
+	for _, h := range wants {
+		for {
+			obj, err := storage.EncodedObject(plumbing.AnyObject, h)
+			if err != nil {
+				return nil, err
+			}
+			if obj.Type() != plumbing.TagObject {
+				break
+			}
+
+			tag := &object.Tag{}
+			if err := tag.Decode(obj); err != nil {
+				return nil, err
+			}
+			if !w.seen[h] {
+				w.seen[h] = true
+				w.objects = append(w.objects, h)
+			}
+			h = tag.Target
+		}
+
+		obj, err := storage.EncodedObject(plumbing.AnyObject, h)
+		if err != nil {
+			return nil, err
+		}
+		switch obj.Type() {
+		case plumbing.CommitObject:
+			if err := w.enqueue(h); err != nil {
+				return nil, err
+			}
+		case plumbing.TreeObject:
+			trees = append(trees, h)
+		default:
+			if !w.seen[h] {
+				w.seen[h] = true
+				w.objects = append(w.objects, h)
+			}
+		}
+	}
+
+	if err := w.walkCommits(); err != nil {
+		return nil, err
+	}
+	// The trees of the commits the client has that are parents of commits to send get compared
+	// with when walking trees
+	var commits, edges []plumbing.Hash
+	isEdge := make(map[plumbing.Hash]bool)
+	for _, h := range w.commits {
+		// Commits committed before their parents may have turned out to be had by the client
+		if w.uninteresting[h] {
+			continue
+		}
+
+		commit, err := object.GetCommit(storage, h)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, h)
+		trees = append(trees, commit.TreeHash)
+		for _, parent := range commit.ParentHashes {
+			if !w.uninteresting[parent] || isEdge[parent] {
+				continue
+			}
+
+			edge, err := object.GetCommit(storage, parent)
+			if err != nil {
+				return nil, err
+			}
+			isEdge[parent] = true
+			edges = append(edges, edge.TreeHash)
+		}
+	}
+	for _, h := range trees {
+		if err := w.walkTree(h, edges); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Debug().Msgf("Determined %d commit(s) and %d other object(s) to send", len(commits),
+		len(w.objects))
+	return append(commits, w.objects...), nil
+}
@@ -0,0 +1,562 @@
+package cli
+
+import (
+	"bytes"
+	encJson "encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cosmos/cosmos-sdk/client/context"
+	"github.com/cosmos/cosmos-sdk/client/tx"
+	"github.com/cosmos/cosmos-sdk/codec"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/x/auth"
+	"github.com/joystream/onchain-git-poc/x/gitService"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+// txSearchPageSize is the number of transactions fetched per transaction search
+const txSearchPageSize = 100
+
+// replayedRepo is a repository rebuilt locally by replaying the messages that changed it.
+// References are held by their stored values, i.e. a hash or "ref: <target>".
+type replayedRepo struct {
+	uri     string
+	exists  bool
+	refs    map[plumbing.ReferenceName]string
+	packs   map[plumbing.Hash]bool
+	objects *memory.Storage
+	reflogs map[plumbing.ReferenceName][]gitService.ReflogEntry
+	// divergences are the messages that the replay found couldn't have succeeded, although they
+	// did on chain
+	divergences []string
+}
+
+func newReplayedRepo(uri string) *replayedRepo {
+	r := &replayedRepo{uri: uri}
+	r.reset(false, "")
+	return r
+}
+
+// reset empties the repository, initializing it with HEAD pointing to a default branch if it
+// exists
+func (r *replayedRepo) reset(exists bool, defaultBranch plumbing.ReferenceName) {
+	r.exists = exists
+	r.refs = map[plumbing.ReferenceName]string{}
+	r.packs = map[plumbing.Hash]bool{}
+	r.objects = memory.NewStorage()
+	r.reflogs = map[plumbing.ReferenceName][]gitService.ReflogEntry{}
+	if exists {
+		r.refs[plumbing.HEAD] = fmt.Sprintf("ref: %s", defaultBranch)
+	}
+}
+
+func (r *replayedRepo) divergef(res *ctypes.ResultTx, format string, args ...interface{}) {
+	d := fmt.Sprintf("Tx %X at height %d: %s", res.Hash, res.Height, fmt.Sprintf(format,
+		args...))
+	log.Debug().Msgf("Divergence: %s", d)
+	r.divergences = append(r.divergences, d)
+}
+
+// resolve resolves a reference to a hash, following symbolic references like the keeper does.
+// The zero hash means that the reference is unborn.
+func (r *replayedRepo) resolve(name plumbing.ReferenceName) plumbing.Hash {
+	for i := 0; i <= gitService.MaxSymrefDepth; i++ {
+		value, ok := r.refs[name]
+		if !ok {
+			break
+		}
+
+		ref := plumbing.NewReferenceFromStrings(name.String(), value)
+		if ref.Type() == plumbing.HashReference {
+			return ref.Hash()
+		}
+		name = ref.Target()
+	}
+
+	return plumbing.ZeroHash
+}
+
+func (r *replayedRepo) hasObject(h plumbing.Hash) bool {
+	_, err := r.objects.EncodedObject(plumbing.AnyObject, h)
+	return err == nil
+}
+
+// addPackfile adds the objects of a packfile, returning its checksum. Empty packfiles don't
+// get stored, and the zero hash is returned for them.
+func (r *replayedRepo) addPackfile(pack []byte) (plumbing.Hash, error) {
+	if len(pack) == 0 {
+		return plumbing.ZeroHash, nil
+	}
+	if err := packfile.UpdateObjectStorage(r.objects, bytes.NewReader(pack)); err != nil {
+		if err == packfile.ErrEmptyPackfile {
+			return plumbing.ZeroHash, nil
+		}
+		return plumbing.ZeroHash, err
+	}
+
+	var checksum plumbing.Hash
+	copy(checksum[:], pack[len(pack)-20:])
+	r.packs[checksum] = true
+	return checksum, nil
+}
+
+func (r *replayedRepo) appendReflog(res *ctypes.ResultTx, name plumbing.ReferenceName,
+	old plumbing.Hash, new plumbing.Hash, author sdk.AccAddress) {
+	// Like the keeper, the entry follows the last one
+	var index uint64
+	if entries := r.reflogs[name]; len(entries) > 0 {
+		index = entries[len(entries)-1].Index
+	}
+	r.reflogs[name] = append(r.reflogs[name], gitService.ReflogEntry{
+		Index:  index + 1,
+		Old:    old,
+		New:    new,
+		Author: author,
+		Height: res.Height,
+		TxHash: fmt.Sprintf("%X", res.Hash),
+	})
+}
+
+// seed initializes the repository from the genesis state, in case it got imported
+func (r *replayedRepo) seed(gr gitService.GenesisRepository) error {
+	r.reset(true, "")
+	for _, ref := range gr.Refs {
+		r.refs[ref.Name] = ref.Target
+	}
+	for _, pack := range gr.Packfiles {
+		checksum, err := pack.Checksum()
+		if err != nil {
+			return err
+		}
+		if _, err := r.addPackfile(pack.Pack); err != nil {
+			return fmt.Errorf("Couldn't read genesis packfile %s: %s", checksum, err)
+		}
+	}
+	for _, reflog := range gr.Reflogs {
+		entries := append([]gitService.ReflogEntry{}, reflog.Entries...)
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Index < entries[j].Index })
+		r.reflogs[reflog.Name] = entries
+	}
+
+	return nil
+}
+
+// apply replays a message of a transaction that succeeded on chain, recording a divergence
+// wherever the replayed state says that it shouldn't have. The changes get applied regardless,
+// so that later messages are replayed against the state the chain has.
+func (r *replayedRepo) apply(res *ctypes.ResultTx, msg sdk.Msg) error {
+	switch msg := msg.(type) {
+	case gitService.MsgCreateRepository:
+		if r.exists {
+			r.divergef(res, "repository got created although it existed")
+		}
+		defaultBranch := msg.DefaultBranch
+		if defaultBranch == "" {
+			defaultBranch = plumbing.Master
+		}
+		r.reset(true, defaultBranch)
+	case gitService.MsgUpdateReferences:
+		return r.applyUpdate(res, msg)
+	case gitService.MsgSetSymbolicReference:
+		if !r.exists {
+			r.divergef(res, "symbolic reference '%s' got set in missing repository", msg.Name)
+		}
+		r.refs[msg.Name] = fmt.Sprintf("ref: %s", msg.Target)
+	case gitService.MsgRestoreReference:
+		var entry gitService.ReflogEntry
+		for _, e := range r.reflogs[msg.Name] {
+			if e.Index == msg.Index {
+				entry = e
+			}
+		}
+		if entry.New.IsZero() {
+			r.divergef(res, "reference '%s' got restored to missing or deletion reflog entry %d",
+				msg.Name, msg.Index)
+			return nil
+		}
+		if !r.hasObject(entry.New) {
+			r.divergef(res, "reference '%s' got restored to missing object %s", msg.Name,
+				entry.New)
+		}
+		old := r.resolve(msg.Name)
+		r.refs[msg.Name] = entry.New.String()
+		r.appendReflog(res, msg.Name, old, entry.New, msg.Author)
+	case gitService.MsgRemoveRepository:
+		r.reset(false, "")
+	}
+
+	return nil
+}
+
+func (r *replayedRepo) applyUpdate(res *ctypes.ResultTx,
+	msg gitService.MsgUpdateReferences) error {
+	if !r.exists {
+		r.reset(true, gitService.DefaultBranchForPush(msg))
+	}
+	if _, err := r.addPackfile(msg.Packfile); err != nil {
+		return fmt.Errorf("Couldn't read packfile of tx %X: %s", res.Hash, err)
+	}
+
+	olds := make([]plumbing.Hash, len(msg.Commands))
+	for i, cmd := range msg.Commands {
+		olds[i] = r.resolve(cmd.Name)
+	}
+	for i, cmd := range msg.Commands {
+		_, exists := r.refs[cmd.Name]
+		switch cmd.Action() {
+		case gitService.CreateAction:
+			if exists {
+				r.divergef(res, "reference '%s' got created although it existed", cmd.Name)
+			}
+		case packp.Update, packp.Delete:
+			if !exists {
+				r.divergef(res, "reference '%s' got changed although it didn't exist", cmd.Name)
+			} else if olds[i] != cmd.Old {
+				r.divergef(res, "reference '%s' got changed from %s, but pointed to %s", cmd.Name,
+					cmd.Old, olds[i])
+			}
+		}
+
+		if cmd.Action() == packp.Delete {
+			delete(r.refs, cmd.Name)
+			continue
+		}
+		if !r.hasObject(cmd.New) {
+			r.divergef(res, "reference '%s' got pointed to missing object %s", cmd.Name, cmd.New)
+		}
+		r.refs[cmd.Name] = cmd.New.String()
+	}
+	for i, cmd := range msg.Commands {
+		r.appendReflog(res, cmd.Name, olds[i], cmd.New, msg.Author)
+	}
+
+	return nil
+}
+
+// queryGenesisRepo gets a repository from the genesis state, nil if it wasn't imported
+func queryGenesisRepo(cliCtx context.CLIContext, cdc *codec.Codec, uri string) (
+	*gitService.GenesisRepository, error) {
+	node, err := cliCtx.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	res, err := node.Genesis()
+	if err != nil {
+		return nil, err
+	}
+
+	var appState map[string]encJson.RawMessage
+	if err := encJson.Unmarshal(res.Genesis.AppState, &appState); err != nil {
+		return nil, err
+	}
+	raw, ok := appState["git_service"]
+	if !ok {
+		return nil, nil
+	}
+	var state gitService.GenesisState
+	if err := cdc.UnmarshalJSON(raw, &state); err != nil {
+		return nil, err
+	}
+	for _, repo := range state.Repositories {
+		if repo.Repository.URI == uri {
+			return &repo, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// queryRepoTxs finds the transactions concerning a repository up to a height, via the tags
+// indexed by the node, in the order they got applied. Unless the node is trusted, they get
+// verified against the headers.
+func queryRepoTxs(cliCtx context.CLIContext, uri string, height int64) ([]*ctypes.ResultTx,
+	error) {
+	node, err := cliCtx.GetNode()
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("%s='%s' AND tx.height<=%d", gitService.TagRepo, uri, height)
+	var txs []*ctypes.ResultTx
+	for page := 1; ; page++ {
+		res, err := node.TxSearch(query, !cliCtx.TrustNode, page, txSearchPageSize)
+		if err != nil {
+			return nil, err
+		}
+		log.Debug().Msgf("Found %d of %d transactions on page %d", len(res.Txs), res.TotalCount,
+			page)
+		txs = append(txs, res.Txs...)
+		if len(res.Txs) == 0 || len(txs) >= res.TotalCount {
+			break
+		}
+	}
+
+	if !cliCtx.TrustNode {
+		for _, res := range txs {
+			if err := tx.ValidateTxResult(cliCtx, res); err != nil {
+				return nil, fmt.Errorf("Couldn't verify tx %X: %s", res.Hash, err)
+			}
+		}
+	}
+	sort.Slice(txs, func(i, j int) bool {
+		if txs[i].Height != txs[j].Height {
+			return txs[i].Height < txs[j].Height
+		}
+		return txs[i].Index < txs[j].Index
+	})
+	return txs, nil
+}
+
+// replayRepo rebuilds a repository up to a height, from the genesis state and the transactions
+// that changed it
+func replayRepo(cliCtx context.CLIContext, cdc *codec.Codec, uri string, height int64) (
+	*replayedRepo, int, error) {
+	repo := newReplayedRepo(uri)
+	gr, err := queryGenesisRepo(cliCtx, cdc, uri)
+	if err != nil {
+		return nil, 0, err
+	}
+	if gr != nil {
+		log.Debug().Msgf("Seeding repo '%s' from the genesis state", uri)
+		if err := repo.seed(*gr); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	txs, err := queryRepoTxs(cliCtx, uri, height)
+	if err != nil {
+		return nil, 0, err
+	}
+	decode := auth.DefaultTxDecoder(cdc)
+	for _, res := range txs {
+		// Failed transactions change nothing
+		if res.TxResult.Code != 0 {
+			continue
+		}
+		stdTx, err := decode(res.Tx)
+		if err != nil {
+			return nil, 0, fmt.Errorf("Couldn't decode tx %X: %s", res.Hash, err)
+		}
+		for _, msg := range stdTx.GetMsgs() {
+			if msgURI(msg) != uri {
+				continue
+			}
+			log.Debug().Msgf("Replaying %s message of tx %X at height %d", msg.Type(), res.Hash,
+				res.Height)
+			if err := repo.apply(res, msg); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
+	return repo, len(txs), nil
+}
+
+// msgURI gets the URI of the repository a gitService message concerns, empty for other messages
+func msgURI(msg sdk.Msg) string {
+	switch msg := msg.(type) {
+	case gitService.MsgCreateRepository:
+		return msg.URI
+	case gitService.MsgUpdateReferences:
+		return msg.URI
+	case gitService.MsgSetSymbolicReference:
+		return msg.URI
+	case gitService.MsgRestoreReference:
+		return msg.URI
+	case gitService.MsgRemoveRepository:
+		return msg.URI
+	}
+
+	return ""
+}
+
+// compareChainState compares a replayed repository with the state on chain at the height
+// queries are pinned to, returning the differences
+func compareChainState(cliCtx context.CLIContext, moduleName string, repo *replayedRepo) (
+	[]string, error) {
+	var diffs []string
+	lines, _, err := queryRefLines(cliCtx, moduleName, repo.uri,
+		gitService.RefsQuery{Symrefs: true})
+	if err != nil {
+		return nil, err
+	}
+	chainRefs := map[plumbing.ReferenceName]string{}
+	for _, line := range lines {
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Malformed reference line: '%s'", line)
+		}
+		value := parts[0]
+		if strings.HasPrefix(value, "@") {
+			value = fmt.Sprintf("ref: %s", value[1:])
+		}
+		chainRefs[plumbing.ReferenceName(parts[1])] = value
+	}
+	for _, name := range sortedRefNames(repo.refs, chainRefs) {
+		replayed, chain := repo.refs[name], chainRefs[name]
+		if replayed != chain {
+			diffs = append(diffs, fmt.Sprintf("Reference '%s' is '%s' on chain, but '%s' replayed",
+				name, chain, replayed))
+		}
+	}
+
+	res, err := cliCtx.QueryWithData(fmt.Sprintf("custom/%s/packfiles/%s", moduleName,
+		repo.uri), nil)
+	if err != nil {
+		return nil, err
+	}
+	var packs []string
+	if err := encJson.Unmarshal(res, &packs); err != nil {
+		return nil, err
+	}
+	chainPacks := map[plumbing.Hash]bool{}
+	for _, pack := range packs {
+		chainPacks[plumbing.NewHash(pack)] = true
+		if !repo.packs[plumbing.NewHash(pack)] {
+			diffs = append(diffs, fmt.Sprintf("Packfile %s is stored on chain, but not replayed",
+				pack))
+		}
+	}
+	for pack := range repo.packs {
+		if !chainPacks[pack] {
+			diffs = append(diffs, fmt.Sprintf("Packfile %s is replayed, but not stored on chain",
+				pack))
+		}
+	}
+
+	// Deleted references keep their logs, which only the replay knows of
+	logged := map[plumbing.ReferenceName]string{}
+	for name := range repo.reflogs {
+		logged[name] = ""
+	}
+	for _, name := range sortedRefNames(logged, chainRefs) {
+		if name == plumbing.HEAD {
+			continue
+		}
+		reflogDiffs, err := compareReflog(cliCtx, moduleName, repo.uri, name, repo.reflogs[name])
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, reflogDiffs...)
+	}
+
+	return diffs, nil
+}
+
+// compareReflog compares the replayed log of a reference with the one on chain
+func compareReflog(cliCtx context.CLIContext, moduleName string, uri string,
+	name plumbing.ReferenceName, replayed []gitService.ReflogEntry) ([]string, error) {
+	var chain []gitService.ReflogEntry
+	query := gitService.ReflogQuery{Limit: gitService.MaxReflogPageSize}
+	for {
+		resp, err := queryReflog(cliCtx, moduleName, uri, name, query)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, resp.Entries...)
+		if resp.Next == 0 {
+			break
+		}
+		query.Before = resp.Next
+	}
+	// Entries are listed the newest first
+	sort.Slice(chain, func(i, j int) bool { return chain[i].Index < chain[j].Index })
+
+	var diffs []string
+	if len(chain) != len(replayed) {
+		diffs = append(diffs, fmt.Sprintf("Reflog of '%s' has %d entries on chain, but %d replayed",
+			name, len(chain), len(replayed)))
+	}
+	for i := 0; i < len(chain) && i < len(replayed); i++ {
+		c, r := chain[i], replayed[i]
+		if c.Index != r.Index || c.Old != r.Old || c.New != r.New || !c.Author.Equals(r.Author) ||
+			c.Height != r.Height || c.TxHash != r.TxHash {
+			diffs = append(diffs, fmt.Sprintf("Reflog entry is '%s' on chain, but '%s' replayed",
+				formatReflogEntry(name, c), formatReflogEntry(name, r)))
+		}
+	}
+
+	return diffs, nil
+}
+
+// sortedRefNames returns the union of the names of sets of references, sorted
+func sortedRefNames(refSets ...map[plumbing.ReferenceName]string) []plumbing.ReferenceName {
+	set := map[plumbing.ReferenceName]bool{}
+	for _, refs := range refSets {
+		for name := range refs {
+			set[name] = true
+		}
+	}
+
+	names := make([]plumbing.ReferenceName, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// GetCmdReplay returns Cobra command for rebuilding a repository from its transactions and
+// comparing it with the state on chain
+func GetCmdReplay(moduleName string, cdc *codec.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "replay URI",
+		Short: "Rebuild a repository from its transactions and compare it with the chain state",
+		Long: `Rebuild a repository locally, from the genesis state and the transactions that
+changed it as found in the node's transaction index, and compare its references, packfiles and
+reflogs with the state on chain at the same height. Any differences, and any transactions that
+the replayed state says couldn't have succeeded, get listed and the command fails. This audits
+the keeper, e.g. after an upgrade. The node must index the repo tag and, to compare at an
+earlier height, keep the state at it.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			uri := args[0]
+			cliCtx := context.NewCLIContext().WithCodec(cdc)
+			if cliCtx.Height <= 0 {
+				node, err := cliCtx.GetNode()
+				if err != nil {
+					return err
+				}
+				status, err := node.Status()
+				if err != nil {
+					return err
+				}
+				// The app hash of the state at a height is in the header of the next block
+				cliCtx.Height = status.SyncInfo.LatestBlockHeight - 1
+			}
+			log.Debug().Msgf("Replaying repo '%s' up to height %d", uri, cliCtx.Height)
+
+			repo, count, err := replayRepo(cliCtx, cdc, uri, cliCtx.Height)
+			if err != nil {
+				return err
+			}
+			diffs, err := compareChainState(cliCtx, moduleName, repo)
+			if err != nil {
+				return err
+			}
+
+			for _, d := range append(repo.divergences, diffs...) {
+				fmt.Println(d)
+			}
+			if len(repo.divergences) > 0 || len(diffs) > 0 {
+				return fmt.Errorf("Replaying %s up to height %d diverges from the chain state",
+					uri, cliCtx.Height)
+			}
+			fmt.Fprintf(os.Stderr,
+				"Replayed %d transactions of %s up to height %d: %d references, %d packfiles, "+
+					"matching the chain state\n", count, uri, cliCtx.Height, len(repo.refs),
+				len(repo.packs))
+			return nil
+		},
+	}
+	return cmd
+}
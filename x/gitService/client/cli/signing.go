@@ -0,0 +1,167 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	cosmosClient "github.com/cosmos/cosmos-sdk/client"
+	cosmosContext "github.com/cosmos/cosmos-sdk/client/context"
+	"github.com/cosmos/cosmos-sdk/client/keys"
+	"github.com/cosmos/cosmos-sdk/client/utils"
+	"github.com/cosmos/cosmos-sdk/codec"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	authtxb "github.com/cosmos/cosmos-sdk/x/auth/client/txbuilder"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+)
+
+const (
+	flagRemote = "remote"
+	// envKey is the environment variable for the name of the key to sign with
+	envKey = "JOYSTREAM_KEY"
+	// envPassphrase is the environment variable for the passphrase of the key to sign with
+	envPassphrase = "JOYSTREAM_PASSPHRASE"
+)
+
+// resolveSigningKey determines the key to sign transactions with. In order of precedence, it's
+// taken from the --from flag, the JOYSTREAM_KEY environment variable, Git config
+// remote.<remote>.joystreamKey, joystream.<chainID>.key and joystream.key.
+func resolveSigningKey(remote string) (string, error) {
+	if key := viper.GetString(cosmosClient.FlagFrom); key != "" {
+		return key, nil
+	}
+	if key := os.Getenv(envKey); key != "" {
+		log.Debug().Msgf("Got signing key from environment variable %s", envKey)
+		return key, nil
+	}
+
+	var configKeys []string
+	if remote != "" {
+		configKeys = append(configKeys, fmt.Sprintf("remote.%s.joystreamKey", remote))
+	}
+	if chainID := viper.GetString(cosmosClient.FlagChainID); chainID != "" {
+		configKeys = append(configKeys, fmt.Sprintf("joystream.%s.key", chainID))
+	}
+	configKeys = append(configKeys, "joystream.key")
+	return firstGitConfig(configKeys...)
+}
+
+// resolvePassphrase determines the passphrase of the signing key, either from the
+// JOYSTREAM_PASSPHRASE environment variable, or from the output of the command configured as
+// Git config remote.<remote>.joystreamPassphraseCommand or joystream.passphraseCommand.
+// An empty passphrase means that it isn't configured and should be prompted for.
+func resolvePassphrase(remote string) (string, error) {
+	if passphrase := os.Getenv(envPassphrase); passphrase != "" {
+		log.Debug().Msgf("Got passphrase from environment variable %s", envPassphrase)
+		return passphrase, nil
+	}
+
+	var configKeys []string
+	if remote != "" {
+		configKeys = append(configKeys, fmt.Sprintf("remote.%s.joystreamPassphraseCommand", remote))
+	}
+	configKeys = append(configKeys, "joystream.passphraseCommand")
+	command, err := firstGitConfig(configKeys...)
+	if err != nil || command == "" {
+		return "", err
+	}
+
+	log.Debug().Msgf("Getting passphrase from command '%s'", command)
+	var stdout bytes.Buffer
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("Passphrase command '%s' failed: %s", command, err)
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// getSigningContext returns a CLIContext for signing with the resolved key, along with the key's
+// passphrase if configured
+func getSigningContext(cdc *codec.Codec, remote string) (cosmosContext.CLIContext, string,
+	error) {
+	key, err := resolveSigningKey(remote)
+	if err != nil {
+		return cosmosContext.CLIContext{}, "", err
+	}
+	if key == "" {
+		return cosmosContext.CLIContext{}, "", fmt.Errorf(
+			"No signing key configured, set --from, %s or Git config joystream.key", envKey)
+	}
+	log.Debug().Msgf("Signing with key '%s'", key)
+	// The CLIContext derives the signer's address from the from flag
+	viper.Set(cosmosClient.FlagFrom, key)
+	cliCtx := cosmosContext.NewCLIContext().WithCodec(cdc).WithAccountDecoder(cdc)
+
+	passphrase, err := resolvePassphrase(remote)
+	if err != nil {
+		return cliCtx, "", err
+	}
+
+	return cliCtx, passphrase, nil
+}
+
+// completeAndBroadcastTx signs a transaction containing msgs and broadcasts it. If passphrase
+// is empty, it gets prompted for. In dry-run mode, the transaction only gets simulated and the
+// returned result is nil.
+func completeAndBroadcastTx(txBldr authtxb.TxBuilder, cliCtx cosmosContext.CLIContext,
+	msgs []sdk.Msg, passphrase string) (*ctypes.ResultBroadcastTxCommit, error) {
+	if err := cliCtx.EnsureAccountExists(); err != nil {
+		return nil, err
+	}
+	from, err := cliCtx.GetFromAddress()
+	if err != nil {
+		return nil, err
+	}
+	name, err := cliCtx.GetFromName()
+	if err != nil {
+		return nil, err
+	}
+
+	if txBldr.AccountNumber == 0 {
+		accNum, err := cliCtx.GetAccountNumber(from)
+		if err != nil {
+			return nil, err
+		}
+		txBldr = txBldr.WithAccountNumber(accNum)
+	}
+	if txBldr.Sequence == 0 {
+		accSeq, err := cliCtx.GetAccountSequence(from)
+		if err != nil {
+			return nil, err
+		}
+		txBldr = txBldr.WithSequence(accSeq)
+	}
+
+	if txBldr.SimulateGas || cliCtx.DryRun {
+		txBldr, err = utils.EnrichCtxWithGas(txBldr, cliCtx, name, msgs)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "estimated gas = %v\n", txBldr.Gas)
+	}
+	if cliCtx.DryRun {
+		log.Debug().Msgf("Dry run, not broadcasting transaction")
+		return nil, nil
+	}
+
+	if passphrase == "" {
+		passphrase, err = keys.GetPassphrase(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	txBytes, err := txBldr.BuildAndSign(name, passphrase, msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	return cliCtx.BroadcastTx(txBytes)
+}
@@ -2,22 +2,28 @@ package cli
 
 import (
 	stdContext "context"
+	"fmt"
+	"io"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/cosmos/cosmos-sdk/client/context"
-	"github.com/cosmos/cosmos-sdk/client/utils"
 	"github.com/cosmos/cosmos-sdk/codec"
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	authtxb "github.com/cosmos/cosmos-sdk/x/auth/client/txbuilder"
 	"github.com/joystream/onchain-git-poc/x/gitService"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"gopkg.in/src-d/go-billy.v4/osfs"
 	gogit "gopkg.in/src-d/go-git.v4"
 	gogitcfg "gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/cache"
 	gogitobj "gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
 	gogitstor "gopkg.in/src-d/go-git.v4/plumbing/storer"
 	"gopkg.in/src-d/go-git.v4/storage/filesystem"
 )
@@ -25,6 +31,14 @@ import (
 const (
 	maxCommitsToVisitPerRef = 20
 	localRepoRemoteName     = "local"
+
+	flagAtomic     = "atomic"
+	flagFollowTags = "follow-tags"
+	flagProgress   = "progress"
+	flagPushOption = "push-option"
+	flagPorcelain  = "porcelain"
+	// flagDefaultBranch is the flag for the branch for HEAD to point to in a new repository
+	flagDefaultBranch = "default-branch"
 )
 
 type refData struct {
@@ -112,11 +126,16 @@ func getParentCommitsForRef(refSpec gogitcfg.RefSpec, repo *gogit.Repository,
 }
 
 func pushRefs(ctx stdContext.Context, uri string, refs []string, txBldr authtxb.TxBuilder,
-	cliCtx context.CLIContext, author sdk.AccAddress, moduleName string) error {
+	cliCtx context.CLIContext, author sdk.AccAddress, passphrase string, opts *pushOptions,
+	porcelain bool, moduleName string) error {
 	log.Debug().Msgf("Pushing refs %v from local to blockchain repo '%s'", refs, uri)
 
-	// TODO: Support getting repo dir from user
-	localRepoPath, err := filepath.Abs(".git")
+	// When invoked via the Git remote helper, Git tells us where the repository is
+	localRepoPath := os.Getenv("GIT_DIR")
+	if localRepoPath == "" {
+		localRepoPath = ".git"
+	}
+	localRepoPath, err := filepath.Abs(localRepoPath)
 	if err != nil {
 		return err
 	}
@@ -141,10 +160,23 @@ func pushRefs(ctx stdContext.Context, uri string, refs []string, txBldr authtxb.
 		refSpecs = append(refSpecs, refSpec)
 	}
 
-	err = pushToBlockChain(ctx, uri, refSpecs, repo, cliCtx, txBldr, author, moduleName)
+	rs, err := pushToBlockChain(ctx, uri, refSpecs, repo, cliCtx, txBldr, author, passphrase,
+		opts, moduleName)
+	if err == errAlreadyUpToDate {
+		log.Debug().Msgf("Nothing to push")
+		err = nil
+	}
+	if porcelain {
+		printPushReport(refSpecs, rs, err)
+	}
 	if err != nil {
 		return err
 	}
+	if rs != nil {
+		if err := rs.Error(); err != nil {
+			return err
+		}
+	}
 
 	// err = r.waitForJournal(ctx)
 	// if err != nil {
@@ -200,15 +232,59 @@ func pushRefs(ctx stdContext.Context, uri string, refs []string, txBldr authtxb.
 	return nil
 }
 
+// printPushReport prints the status of each pushed reference to stdout, on the format
+// of the Git remote helper push command (i.e. "ok <ref>" or "error <ref> <why>")
+func printPushReport(refSpecs []gogitcfg.RefSpec, rs *packp.ReportStatus, pushErr error) {
+	reported := make(map[plumbing.ReferenceName]bool)
+	if rs != nil {
+		for _, cs := range rs.CommandStatuses {
+			reported[cs.ReferenceName] = true
+			if cs.Status == "ok" && pushErr == nil {
+				fmt.Printf("ok %s\n", cs.ReferenceName)
+			} else if cs.Status == "ok" {
+				fmt.Printf("error %s %s\n", cs.ReferenceName, pushErr)
+			} else {
+				fmt.Printf("error %s %s\n", cs.ReferenceName, cs.Status)
+			}
+		}
+	}
+
+	// References we didn't push (e.g. since they're up to date) are reported according to the
+	// outcome of the push as a whole
+	for _, refSpec := range refSpecs {
+		if refSpec.IsWildcard() {
+			continue
+		}
+		dst := refSpec.Dst("")
+		if reported[dst] {
+			continue
+		}
+
+		if pushErr == nil {
+			fmt.Printf("ok %s\n", dst)
+		} else {
+			fmt.Printf("error %s %s\n", dst, pushErr)
+		}
+	}
+}
+
 // GetCmdPushRefs is the CLI command for pushing Git refs to the blockchain
 func GetCmdPushRefs(moduleName string, cdc *codec.Codec) *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "push-refs repo ref...",
 		Short: "Push Git refs to a certain repository on the blockchain",
 		Args:  cobra.MinimumNArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			log.Debug().Msgf("Executing CmdPushRefs")
-			cliCtx := context.NewCLIContext().WithCodec(cdc).WithAccountDecoder(cdc)
+			cliCtx, passphrase, err := getSigningContext(cdc, viper.GetString(flagRemote))
+			if err != nil {
+				return err
+			}
+			porcelain := viper.GetBool(flagPorcelain)
+			if porcelain {
+				// Stdout is reserved for the push report
+				cliCtx = cliCtx.WithOutput(os.Stderr)
+			}
 			if err := cliCtx.EnsureAccountExists(); err != nil {
 				return err
 			}
@@ -220,19 +296,57 @@ func GetCmdPushRefs(moduleName string, cdc *codec.Codec) *cobra.Command {
 
 			repo := args[0]
 
+			// Viper would mangle the string array
+			serverOptions, err := cmd.Flags().GetStringArray(flagPushOption)
+			if err != nil {
+				return err
+			}
+
 			txBldr := authtxb.NewTxBuilderFromCLI().WithCodec(cdc)
 			ctx := stdContext.Background()
-			if err := pushRefs(ctx, repo, args[1:], txBldr, cliCtx, author, moduleName); err != nil {
+			opts := &pushOptions{
+				atomic:        viper.GetBool(flagAtomic),
+				followTags:    viper.GetBool(flagFollowTags),
+				progress:      viper.GetBool(flagProgress),
+				serverOptions: serverOptions,
+				defaultBranch: branchName(viper.GetString(flagDefaultBranch)),
+			}
+			if err := pushRefs(ctx, repo, args[1:], txBldr, cliCtx, author, passphrase, opts,
+				porcelain, moduleName); err != nil {
 				return err
 			}
 
 			return nil
 		},
 	}
+	cmd.Flags().String(flagRemote, "",
+		"Name of the Git remote, for looking up remote.<name>.joystreamKey in Git config")
+	cmd.Flags().Bool(flagAtomic, false,
+		"Either update all references or none, if any of them can't be updated")
+	cmd.Flags().Bool(flagFollowTags, false,
+		"Also push annotated tags pointing to commits reachable from the pushed references")
+	cmd.Flags().Bool(flagProgress, false, "Report progress on stderr")
+	cmd.Flags().StringArray(flagPushOption, nil, "Push option to transmit to the chain")
+	cmd.Flags().Bool(flagPorcelain, false,
+		"Print the status of each reference to stdout, on the Git remote helper format")
+	cmd.Flags().String(flagDefaultBranch, "",
+		"Branch for HEAD to point to if the push creates the repository, e.g. main "+
+			"(defaults to a pushed branch, preferably master)")
+
+	return cmd
+}
+
+// branchName expands a short branch name, such as main, to a full reference name
+func branchName(name string) plumbing.ReferenceName {
+	if name == "" || strings.HasPrefix(name, "refs/") {
+		return plumbing.ReferenceName(name)
+	}
+
+	return plumbing.ReferenceName("refs/heads/" + name)
 }
 
 func removeRepo(ctx stdContext.Context, uri string, txBldr authtxb.TxBuilder,
-	cliCtx context.CLIContext, author sdk.AccAddress, moduleName string) error {
+	cliCtx context.CLIContext, author sdk.AccAddress, passphrase string, moduleName string) error {
 	log.Debug().Msgf("Removing repository '%s' from blockchain", uri)
 	msg, err := gitService.NewMsgRemoveRepository(uri, author)
 	if err != nil {
@@ -242,7 +356,7 @@ func removeRepo(ctx stdContext.Context, uri string, txBldr authtxb.TxBuilder,
 	log.Debug().Msgf("Joystream client sending MsgRemoveRepository to server for repo '%s'",
 		msg.URI)
 
-	if err := utils.CompleteAndBroadcastTxCli(txBldr, cliCtx, []sdk.Msg{msg}); err != nil {
+	if _, err := completeAndBroadcastTx(txBldr, cliCtx, []sdk.Msg{msg}, passphrase); err != nil {
 		log.Debug().Msgf("Sending MsgRemoveRepository to node failed: %s", err)
 		return err
 	}
@@ -254,11 +368,14 @@ func removeRepo(ctx stdContext.Context, uri string, txBldr authtxb.TxBuilder,
 func GetCmdRemoveRepo(moduleName string, cdc *codec.Codec) *cobra.Command {
 	return &cobra.Command{
 		Use:   "remove-repo repo",
-		Short: "Remove a Git repository on the blockchain",
+		Short: "Remove a Git repository on the blockchain, which only its admins may do",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			log.Debug().Msgf("Executing CmdRemoveRepo")
-			cliCtx := context.NewCLIContext().WithCodec(cdc).WithAccountDecoder(cdc)
+			cliCtx, passphrase, err := getSigningContext(cdc, "")
+			if err != nil {
+				return err
+			}
 			if err := cliCtx.EnsureAccountExists(); err != nil {
 				return err
 			}
@@ -272,11 +389,301 @@ func GetCmdRemoveRepo(moduleName string, cdc *codec.Codec) *cobra.Command {
 
 			txBldr := authtxb.NewTxBuilderFromCLI().WithCodec(cdc)
 			ctx := stdContext.Background()
-			if err := removeRepo(ctx, repo, txBldr, cliCtx, author, moduleName); err != nil {
+			if err := removeRepo(ctx, repo, txBldr, cliCtx, author, passphrase,
+				moduleName); err != nil {
+				return err
+			}
+
+			return nil
+		},
+	}
+}
+
+func setSymref(uri string, name plumbing.ReferenceName, target plumbing.ReferenceName,
+	txBldr authtxb.TxBuilder, cliCtx context.CLIContext, author sdk.AccAddress,
+	passphrase string) error {
+	log.Debug().Msgf("Pointing reference '%s' of repository '%s' to '%s'", name, uri, target)
+	msg, err := gitService.NewMsgSetSymbolicReference(uri, name, target, author)
+	if err != nil {
+		log.Debug().Msgf("Joystream client failed to create MsgSetSymbolicReference: %s", err)
+		return err
+	}
+
+	if _, err := completeAndBroadcastTx(txBldr, cliCtx, []sdk.Msg{msg}, passphrase); err != nil {
+		log.Debug().Msgf("Sending MsgSetSymbolicReference to node failed: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetCmdSetSymref is the CLI command for pointing a symbolic reference, e.g. HEAD, of a
+// repository on the blockchain to another reference
+func GetCmdSetSymref(moduleName string, cdc *codec.Codec) *cobra.Command {
+	return &cobra.Command{
+		Use:   "set-symref repo name target",
+		Short: "Point a symbolic reference, e.g. HEAD, to another reference",
+		Long: `Point a symbolic reference, e.g. HEAD, to another reference. Setting HEAD
+determines the default branch, i.e. what clones check out. Only admins of the repository may
+do so. Branch names may be given in short form, e.g. main.`,
+		Args: cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log.Debug().Msgf("Executing CmdSetSymref")
+			cliCtx, passphrase, err := getSigningContext(cdc, "")
+			if err != nil {
+				return err
+			}
+			if err := cliCtx.EnsureAccountExists(); err != nil {
+				return err
+			}
+
+			author, err := cliCtx.GetFromAddress()
+			if err != nil {
+				return err
+			}
+
+			name := plumbing.ReferenceName(args[1])
+			if name != plumbing.HEAD {
+				name = branchName(args[1])
+			}
+			txBldr := authtxb.NewTxBuilderFromCLI().WithCodec(cdc)
+			return setSymref(args[0], name, branchName(args[2]), txBldr, cliCtx, author,
+				passphrase)
+		},
+	}
+}
+
+const (
+	flagDescription = "description"
+	flagVisibility  = "visibility"
+	flagLicense     = "license"
+	flagTopic       = "topic"
+)
+
+func createRepo(msg *gitService.MsgCreateRepository, txBldr authtxb.TxBuilder,
+	cliCtx context.CLIContext, passphrase string) error {
+	log.Debug().Msgf("Creating repository '%s' on blockchain", msg.URI)
+	if _, err := completeAndBroadcastTx(txBldr, cliCtx, []sdk.Msg{msg}, passphrase); err != nil {
+		log.Debug().Msgf("Sending MsgCreateRepository to node failed: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetCmdCreateRepo is the CLI command for creating a repository with metadata on the blockchain
+func GetCmdCreateRepo(moduleName string, cdc *codec.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create-repo repo",
+		Short: "Create a Git repository on the blockchain",
+		Long: `Create a Git repository on the blockchain, with metadata. Unless the chain requires
+explicit creation, repositories also get created by the first push to them, without metadata.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log.Debug().Msgf("Executing CmdCreateRepo")
+			cliCtx, passphrase, err := getSigningContext(cdc, "")
+			if err != nil {
+				return err
+			}
+			if err := cliCtx.EnsureAccountExists(); err != nil {
+				return err
+			}
+
+			author, err := cliCtx.GetFromAddress()
+			if err != nil {
+				return err
+			}
+
+			// Viper would mangle the string array
+			topics, err := cmd.Flags().GetStringArray(flagTopic)
+			if err != nil {
+				return err
+			}
+			msg, err := gitService.NewMsgCreateRepository(args[0],
+				viper.GetString(flagDescription), branchName(viper.GetString(flagDefaultBranch)),
+				gitService.Visibility(viper.GetString(flagVisibility)),
+				viper.GetString(flagLicense), topics, author)
+			if err != nil {
+				log.Debug().Msgf("Joystream client failed to create MsgCreateRepository: %s", err)
+				return err
+			}
+
+			txBldr := authtxb.NewTxBuilderFromCLI().WithCodec(cdc)
+			return createRepo(msg, txBldr, cliCtx, passphrase)
+		},
+	}
+	cmd.Flags().String(flagDescription, "", "Description of the repository")
+	cmd.Flags().String(flagDefaultBranch, "", "Default branch of the repository (default master)")
+	cmd.Flags().String(flagVisibility, string(gitService.VisibilityPublic),
+		"Visibility of the repository, public or private")
+	cmd.Flags().String(flagLicense, "", "SPDX license identifier, e.g. MIT")
+	cmd.Flags().StringArray(flagTopic, nil, "Topic of the repository (can be repeated)")
+
+	return cmd
+}
+
+// readLine reads a line from r a byte at a time, so that nothing beyond it gets consumed, which
+// matters as the passphrase may get read from the same input afterwards
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				return strings.TrimSpace(string(line)), nil
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF && len(line) > 0 {
+			return strings.TrimSpace(string(line)), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// pickReflogEntry lets the user pick an entry of the newest page of the log of a reference
+func pickReflogEntry(cliCtx context.CLIContext, moduleName string, uri string,
+	name plumbing.ReferenceName) (uint64, error) {
+	resp, err := queryReflog(cliCtx, moduleName, uri, name, gitService.ReflogQuery{})
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Entries) == 0 {
+		return 0, fmt.Errorf("reference '%s' has no reflog", name)
+	}
+
+	for _, entry := range resp.Entries {
+		fmt.Fprintln(os.Stderr, formatReflogEntry(name, entry))
+	}
+	fmt.Fprintf(os.Stderr, "Index of the entry to restore %s to: ", name.Short())
+	answer, err := readLine(os.Stdin)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(answer, 10, 64)
+}
+
+// GetCmdRestoreRef is the CLI command for resetting a reference to what it pointed to after a
+// change recorded in its reflog
+func GetCmdRestoreRef(moduleName string, cdc *codec.Codec) *cobra.Command {
+	return &cobra.Command{
+		Use:   "restore-ref repo ref [index]",
+		Short: "Reset a reference to what it pointed to after an entry in its reflog",
+		Long: `Reset a reference to what it pointed to after an entry in its reflog, e.g. to recover
+from a force push. Without an index, the newest entries get listed to pick one from. Only admins
+of the repository may restore references. Branch names may be given in short form, e.g. main.`,
+		Args: cobra.RangeArgs(2, 3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log.Debug().Msgf("Executing CmdRestoreRef")
+			cliCtx, passphrase, err := getSigningContext(cdc, "")
+			if err != nil {
+				return err
+			}
+			if err := cliCtx.EnsureAccountExists(); err != nil {
+				return err
+			}
+
+			author, err := cliCtx.GetFromAddress()
+			if err != nil {
+				return err
+			}
+
+			uri := args[0]
+			name := branchName(args[1])
+			var index uint64
+			if len(args) == 3 {
+				index, err = strconv.ParseUint(args[2], 10, 64)
+			} else {
+				index, err = pickReflogEntry(cliCtx, moduleName, uri, name)
+			}
+			if err != nil {
+				return fmt.Errorf("invalid reflog index: %s", err)
+			}
+
+			msg, err := gitService.NewMsgRestoreReference(uri, name, index, author)
+			if err != nil {
+				log.Debug().Msgf("Joystream client failed to create MsgRestoreReference: %s", err)
+				return err
+			}
+
+			txBldr := authtxb.NewTxBuilderFromCLI().WithCodec(cdc)
+			if _, err := completeAndBroadcastTx(txBldr, cliCtx, []sdk.Msg{msg},
+				passphrase); err != nil {
+				log.Debug().Msgf("Sending MsgRestoreReference to node failed: %s", err)
+				return err
+			}
+
+			return nil
+		},
+	}
+}
+
+const (
+	flagMaxRepoSize   = "max-repo-size"
+	flagMaxPackSize   = "max-pack-size"
+	flagMaxRefs       = "max-refs"
+	flagMaxObjectSize = "max-object-size"
+	flagClear         = "clear"
+)
+
+// GetCmdSetQuotas is the CLI command for setting the quotas of a repository
+func GetCmdSetQuotas(moduleName string, cdc *codec.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set-quotas repo",
+		Short: "Set the quotas of a repository, overriding the module parameters",
+		Long: `Set the quotas of a repository, overriding the module parameters. Quotas of 0 mean no
+limit. With --clear, the quotas of the module parameters apply to the repository again. Only
+accounts administering all repositories may set quotas.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log.Debug().Msgf("Executing CmdSetQuotas")
+			cliCtx, passphrase, err := getSigningContext(cdc, "")
+			if err != nil {
+				return err
+			}
+			if err := cliCtx.EnsureAccountExists(); err != nil {
+				return err
+			}
+
+			author, err := cliCtx.GetFromAddress()
+			if err != nil {
+				return err
+			}
+
+			var quotas *gitService.Quotas
+			if !viper.GetBool(flagClear) {
+				quotas = &gitService.Quotas{
+					MaxRepoSize:   viper.GetInt64(flagMaxRepoSize),
+					MaxPackSize:   viper.GetInt64(flagMaxPackSize),
+					MaxRefs:       viper.GetInt64(flagMaxRefs),
+					MaxObjectSize: viper.GetInt64(flagMaxObjectSize),
+				}
+			}
+			msg, err := gitService.NewMsgSetRepoQuotas(args[0], quotas, author)
+			if err != nil {
+				log.Debug().Msgf("Joystream client failed to create MsgSetRepoQuotas: %s", err)
+				return err
+			}
+
+			txBldr := authtxb.NewTxBuilderFromCLI().WithCodec(cdc)
+			if _, err := completeAndBroadcastTx(txBldr, cliCtx, []sdk.Msg{msg},
+				passphrase); err != nil {
+				log.Debug().Msgf("Sending MsgSetRepoQuotas to node failed: %s", err)
 				return err
 			}
 
 			return nil
 		},
 	}
+	cmd.Flags().Int64(flagMaxRepoSize, 0, "Maximum size in bytes of the repository")
+	cmd.Flags().Int64(flagMaxPackSize, 0, "Maximum size in bytes of the packfile of a push")
+	cmd.Flags().Int64(flagMaxRefs, 0, "Maximum number of references")
+	cmd.Flags().Int64(flagMaxObjectSize, 0, "Maximum size in bytes of a single object")
+	cmd.Flags().Bool(flagClear, false, "Apply the quotas of the module parameters again")
+
+	return cmd
 }
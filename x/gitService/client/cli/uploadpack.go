@@ -0,0 +1,445 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cosmos/cosmos-sdk/client/context"
+	"github.com/cosmos/cosmos-sdk/codec"
+	"github.com/joystream/onchain-git-poc/x/gitService"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/sideband"
+)
+
+const (
+	flagAdvertiseCapabilities = "advertise-capabilities"
+	packWindow                = 10
+)
+
+// uploadPackRequest is a Git protocol v2 request
+type uploadPackRequest struct {
+	command      string
+	capabilities []string
+	args         []string
+}
+
+// readUploadPackRequest reads a protocol v2 request, which consists of a command, capabilities,
+// a delimiter packet and arguments, terminated by a flush packet
+func readUploadPackRequest(r io.Reader) (*uploadPackRequest, error) {
+	req := &uploadPackRequest{}
+	inArgs := false
+	for {
+		payload, special, err := readPktLine(r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch special {
+		case pktFlush:
+			if req.command == "" {
+				return nil, errors.New("Request lacks a command")
+			}
+			return req, nil
+		case pktDelim:
+			inArgs = true
+			continue
+		case pktResponseEnd:
+			return nil, errors.New("Unexpected response end packet in request")
+		}
+
+		line := strings.TrimSuffix(string(payload), "\n")
+		switch {
+		case inArgs:
+			req.args = append(req.args, line)
+		case strings.HasPrefix(line, "command="):
+			req.command = line[len("command="):]
+		default:
+			req.capabilities = append(req.capabilities, line)
+		}
+	}
+}
+
+// writeCapabilityAdvertisement writes the protocol v2 capability advertisement
+func writeCapabilityAdvertisement(w io.Writer) error {
+	lines := []string{
+		"version 2",
+		fmt.Sprintf("agent=%s", capability.DefaultAgent),
+		"ls-refs",
+		"fetch=filter",
+	}
+	for _, line := range lines {
+		if err := writePktLine(w, "%s\n", line); err != nil {
+			return err
+		}
+	}
+
+	return writeSpecialPkt(w, pktFlush)
+}
+
+// queryRefs queries the references of a repository matching a query, along with the peeled
+// values of annotated tags if requested
+func queryRefs(cliCtx context.CLIContext, moduleName string, uri string,
+	query gitService.RefsQuery) ([]*plumbing.Reference, map[plumbing.ReferenceName]plumbing.Hash,
+	error) {
+	lines, _, err := queryRefLines(cliCtx, moduleName, uri, query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refs := make([]*plumbing.Reference, 0, len(lines))
+	peeled := make(map[plumbing.ReferenceName]plumbing.Hash)
+	for _, line := range lines {
+		parts := strings.Split(line, " ")
+		if len(parts) < 2 {
+			return nil, nil, fmt.Errorf("Malformed reference: '%s'", line)
+		}
+
+		name := plumbing.ReferenceName(parts[1])
+		if strings.HasPrefix(parts[0], "@") {
+			refs = append(refs, plumbing.NewSymbolicReference(name,
+				plumbing.ReferenceName(parts[0][1:])))
+		} else {
+			refs = append(refs, plumbing.NewReferenceFromStrings(name.String(), parts[0]))
+		}
+		for _, attr := range parts[2:] {
+			if strings.HasPrefix(attr, "peeled:") {
+				peeled[name] = plumbing.NewHash(attr[len("peeled:"):])
+			}
+		}
+	}
+
+	return refs, peeled, nil
+}
+
+// resolveSymref resolves the target of a symbolic reference to a hash, returning false if
+// the target doesn't exist
+func resolveSymref(ref *plumbing.Reference, hashes map[plumbing.ReferenceName]plumbing.Hash,
+	cliCtx context.CLIContext, moduleName string, uri string) (plumbing.Hash, bool, error) {
+	if hash, ok := hashes[ref.Target()]; ok {
+		return hash, true, nil
+	}
+
+	// The target didn't match the requested prefixes, so query it separately
+	targets, _, err := queryRefs(cliCtx, moduleName, uri, gitService.RefsQuery{
+		RefPrefixes: []string{ref.Target().String()},
+	})
+	if err != nil {
+		return plumbing.ZeroHash, false, err
+	}
+	for _, target := range targets {
+		if target.Name() == ref.Target() {
+			return target.Hash(), true, nil
+		}
+	}
+
+	return plumbing.ZeroHash, false, nil
+}
+
+// serveLsRefs serves an ls-refs request
+func serveLsRefs(w io.Writer, req *uploadPackRequest, cliCtx context.CLIContext,
+	moduleName string, uri string) error {
+	query := gitService.RefsQuery{Symrefs: true}
+	symrefs := false
+	for _, arg := range req.args {
+		switch {
+		case arg == "symrefs":
+			symrefs = true
+		case arg == "peel":
+			query.Peel = true
+		case strings.HasPrefix(arg, "ref-prefix "):
+			query.RefPrefixes = append(query.RefPrefixes, arg[len("ref-prefix "):])
+		}
+	}
+
+	refs, peeled, err := queryRefs(cliCtx, moduleName, uri, query)
+	if err != nil {
+		return err
+	}
+	hashes := make(map[plumbing.ReferenceName]plumbing.Hash)
+	for _, ref := range refs {
+		if ref.Type() == plumbing.HashReference {
+			hashes[ref.Name()] = ref.Hash()
+		}
+	}
+
+	// References come back HEAD first, followed by the rest in name order
+	for _, ref := range refs {
+		var line string
+		switch ref.Type() {
+		case plumbing.HashReference:
+			line = fmt.Sprintf("%s %s", ref.Hash(), ref.Name())
+			if h, ok := peeled[ref.Name()]; ok {
+				line = fmt.Sprintf("%s peeled:%s", line, h)
+			}
+		case plumbing.SymbolicReference:
+			hash, ok, err := resolveSymref(ref, hashes, cliCtx, moduleName, uri)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				log.Debug().Msgf("Skipping unborn symbolic reference '%s'", ref.Name())
+				continue
+			}
+
+			line = fmt.Sprintf("%s %s", hash, ref.Name())
+			if symrefs {
+				line = fmt.Sprintf("%s symref-target:%s", line, ref.Target())
+			}
+		}
+
+		if err := writePktLine(w, "%s\n", line); err != nil {
+			return err
+		}
+	}
+
+	return writeSpecialPkt(w, pktFlush)
+}
+
+// parseBlobFilter parses an object filter specification, returning the size limit for blobs,
+// where blob:none corresponds to a limit of 0
+func parseBlobFilter(spec string) (int64, error) {
+	if spec == "blob:none" {
+		return 0, nil
+	}
+	if !strings.HasPrefix(spec, "blob:limit=") {
+		return 0, fmt.Errorf("Unsupported filter: '%s'", spec)
+	}
+
+	limitStr := strings.ToLower(spec[len("blob:limit="):])
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(limitStr, "k"):
+		multiplier = 1024
+	case strings.HasSuffix(limitStr, "m"):
+		multiplier = 1024 * 1024
+	case strings.HasSuffix(limitStr, "g"):
+		multiplier = 1024 * 1024 * 1024
+	}
+	limitStr = strings.TrimRight(limitStr, "kmg")
+	limit, err := strconv.ParseInt(limitStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid filter: '%s'", spec)
+	}
+
+	return limit * multiplier, nil
+}
+
+// serveFetch serves a fetch request
+func serveFetch(w io.Writer, req *uploadPackRequest, cliCtx context.CLIContext,
+	moduleName string, uri string) error {
+	var wants, haves []plumbing.Hash
+	done, noProgress, includeTag := false, false, false
+	blobLimit := int64(-1)
+	for _, arg := range req.args {
+		switch {
+		case strings.HasPrefix(arg, "want "):
+			wants = append(wants, plumbing.NewHash(arg[len("want "):]))
+		case strings.HasPrefix(arg, "have "):
+			haves = append(haves, plumbing.NewHash(arg[len("have "):]))
+		case arg == "done":
+			done = true
+		case arg == "no-progress":
+			noProgress = true
+		case arg == "include-tag":
+			includeTag = true
+		case strings.HasPrefix(arg, "filter "):
+			var err error
+			if blobLimit, err = parseBlobFilter(arg[len("filter "):]); err != nil {
+				return err
+			}
+		case arg == "thin-pack", arg == "ofs-delta":
+		default:
+			return fmt.Errorf("Unsupported fetch argument: '%s'", arg)
+		}
+	}
+	log.Debug().Msgf("Serving fetch of %d object(s), %d have(s), done: %t", len(wants),
+		len(haves), done)
+
+	storage := newRemoteObjectStorage(cliCtx, moduleName, uri)
+	var common []plumbing.Hash
+	for _, h := range haves {
+		if storage.HasEncodedObject(h) == nil {
+			common = append(common, h)
+		}
+	}
+	if !done {
+		// We can always produce a packfile from the haves we got, so skip further negotiation
+		if err := writePktLine(w, "acknowledgments\n"); err != nil {
+			return err
+		}
+		if len(common) == 0 {
+			if err := writePktLine(w, "NAK\n"); err != nil {
+				return err
+			}
+		}
+		for _, h := range common {
+			if err := writePktLine(w, "ACK %s\n", h); err != nil {
+				return err
+			}
+		}
+		if err := writePktLine(w, "ready\n"); err != nil {
+			return err
+		}
+		if err := writeSpecialPkt(w, pktDelim); err != nil {
+			return err
+		}
+	}
+
+	hashes, err := fetchObjects(storage, wants, common)
+	if err != nil {
+		return err
+	}
+	if includeTag {
+		if hashes, err = includeTags(hashes, storage, cliCtx, moduleName, uri); err != nil {
+			return err
+		}
+	}
+	if blobLimit >= 0 {
+		if hashes, err = filterBlobs(hashes, wants, blobLimit, storage); err != nil {
+			return err
+		}
+	}
+
+	if err := writePktLine(w, "packfile\n"); err != nil {
+		return err
+	}
+	muxer := sideband.NewMuxer(sideband.Sideband64k, w)
+	if !noProgress {
+		msg := fmt.Sprintf("Sending %d object(s) from %s\n", len(hashes), uri)
+		if _, err := muxer.WriteChannel(sideband.ProgressMessage, []byte(msg)); err != nil {
+			return err
+		}
+	}
+	if _, err := packfile.NewEncoder(muxer, storage, false).Encode(hashes,
+		packWindow); err != nil {
+		return err
+	}
+
+	return writeSpecialPkt(w, pktFlush)
+}
+
+// includeTags adds annotated tags pointing to any of hashes. Only the tags peeling to any of
+// hashes get read, so that the packfiles of other tags don't get loaded.
+func includeTags(hashes []plumbing.Hash, storage *remoteObjectStorage,
+	cliCtx context.CLIContext, moduleName string, uri string) ([]plumbing.Hash, error) {
+	refs, peeled, err := queryRefs(cliCtx, moduleName, uri,
+		gitService.RefsQuery{RefPrefixes: []string{"refs/tags/"}, Peel: true})
+	if err != nil {
+		return nil, err
+	}
+
+	included := make(map[plumbing.Hash]bool)
+	for _, h := range hashes {
+		included[h] = true
+	}
+	for _, ref := range refs {
+		if ref.Type() != plumbing.HashReference || included[ref.Hash()] {
+			continue
+		}
+		if target, ok := peeled[ref.Name()]; !ok || !included[target] {
+			// Not an annotated tag, or one of a tag pointing elsewhere
+			continue
+		}
+
+		tag, err := object.GetTag(storage, ref.Hash())
+		if err != nil {
+			// Not an annotated tag
+			continue
+		}
+		if included[tag.Target] {
+			log.Debug().Msgf("Including tag '%s'", ref.Name())
+			hashes = append(hashes, tag.Hash)
+			included[tag.Hash] = true
+		}
+	}
+
+	return hashes, nil
+}
+
+// filterBlobs filters out blobs larger than limit, unless explicitly wanted
+func filterBlobs(hashes []plumbing.Hash, wants []plumbing.Hash, limit int64,
+	storage *remoteObjectStorage) ([]plumbing.Hash, error) {
+	wanted := make(map[plumbing.Hash]bool)
+	for _, h := range wants {
+		wanted[h] = true
+	}
+
+	filtered := make([]plumbing.Hash, 0, len(hashes))
+	for _, h := range hashes {
+		obj, err := storage.EncodedObject(plumbing.AnyObject, h)
+		if err != nil {
+			return nil, err
+		}
+		if obj.Type() == plumbing.BlobObject && obj.Size() > limit && !wanted[h] {
+			continue
+		}
+
+		filtered = append(filtered, h)
+	}
+
+	log.Debug().Msgf("Filtered out %d blob(s)", len(hashes)-len(filtered))
+	return filtered, nil
+}
+
+// serveUploadPackRequest serves a single protocol v2 request read from r, writing the
+// response to w
+func serveUploadPackRequest(r io.Reader, w io.Writer, cliCtx context.CLIContext,
+	moduleName string, uri string) error {
+	req, err := readUploadPackRequest(r)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msgf("Serving upload-pack request '%s' for repo '%s'", req.command, uri)
+
+	bw := bufio.NewWriter(w)
+	switch req.command {
+	case "ls-refs":
+		err = serveLsRefs(bw, req, cliCtx, moduleName, uri)
+	case "fetch":
+		err = serveFetch(bw, req, cliCtx, moduleName, uri)
+	default:
+		err = fmt.Errorf("Unsupported command: '%s'", req.command)
+	}
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// GetCmdUploadPack returns Cobra command for serving Git protocol v2 upload-pack requests,
+// read from stdin, from a repository on the blockchain. The Git remote helper uses it to
+// implement stateless-connect.
+func GetCmdUploadPack(moduleName string, cdc *codec.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "upload-pack URI",
+		Short: "Serve a Git protocol v2 upload-pack request from stdin for a repository",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if viper.GetBool(flagAdvertiseCapabilities) {
+				return writeCapabilityAdvertisement(os.Stdout)
+			}
+
+			cliCtx, err := pinProvableHeight(context.NewCLIContext().WithCodec(cdc))
+			if err != nil {
+				return err
+			}
+			return serveUploadPackRequest(os.Stdin, os.Stdout, cliCtx, moduleName, args[0])
+		},
+	}
+	cmd.Flags().Bool(flagAdvertiseCapabilities, false,
+		"Only write the protocol v2 capability advertisement")
+
+	return cmd
+}
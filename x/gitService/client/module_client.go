@@ -28,6 +28,16 @@ func (mc ModuleClient) GetQueryCmd() *cobra.Command {
 
 	govQueryCmd.AddCommand(client.GetCommands(
 		gitServiceCmd.GetCmdListRefs(mc.moduleName, mc.cdc),
+		gitServiceCmd.GetCmdUploadPack(mc.moduleName, mc.cdc),
+		gitServiceCmd.GetCmdRepository(mc.moduleName, mc.cdc),
+		gitServiceCmd.GetCmdListRepos(mc.moduleName, mc.cdc),
+		gitServiceCmd.GetCmdSearch(mc.moduleName, mc.cdc),
+		gitServiceCmd.GetCmdReflog(mc.moduleName, mc.cdc),
+		gitServiceCmd.GetCmdStorage(mc.moduleName, mc.cdc),
+		gitServiceCmd.GetCmdQuotas(mc.moduleName, mc.cdc),
+		gitServiceCmd.GetCmdParams(mc.moduleName, mc.cdc),
+		gitServiceCmd.GetCmdProveCommit(mc.moduleName, mc.cdc),
+		gitServiceCmd.GetCmdReplay(mc.moduleName, mc.cdc),
 	)...)
 
 	return govQueryCmd
@@ -44,9 +54,21 @@ func (mc ModuleClient) GetTxCmd() *cobra.Command {
 	govTxCmd.AddCommand(client.PostCommands(
 		gitServiceCmd.GetCmdPushRefs(mc.moduleName, mc.cdc),
 	)...)
+	govTxCmd.AddCommand(client.PostCommands(
+		gitServiceCmd.GetCmdCreateRepo(mc.moduleName, mc.cdc),
+	)...)
 	govTxCmd.AddCommand(client.PostCommands(
 		gitServiceCmd.GetCmdRemoveRepo(mc.moduleName, mc.cdc),
 	)...)
+	govTxCmd.AddCommand(client.PostCommands(
+		gitServiceCmd.GetCmdSetSymref(mc.moduleName, mc.cdc),
+	)...)
+	govTxCmd.AddCommand(client.PostCommands(
+		gitServiceCmd.GetCmdRestoreRef(mc.moduleName, mc.cdc),
+	)...)
+	govTxCmd.AddCommand(client.PostCommands(
+		gitServiceCmd.GetCmdSetQuotas(mc.moduleName, mc.cdc),
+	)...)
 
 	return govTxCmd
 }
@@ -8,4 +8,8 @@ import (
 func RegisterCodec(cdc *codec.Codec) {
 	cdc.RegisterConcrete(MsgUpdateReferences{}, "gitService/UpdateReferences", nil)
 	cdc.RegisterConcrete(MsgRemoveRepository{}, "gitService/RemoveReferences", nil)
+	cdc.RegisterConcrete(MsgSetSymbolicReference{}, "gitService/SetSymbolicReference", nil)
+	cdc.RegisterConcrete(MsgCreateRepository{}, "gitService/CreateRepository", nil)
+	cdc.RegisterConcrete(MsgRestoreReference{}, "gitService/RestoreReference", nil)
+	cdc.RegisterConcrete(MsgSetRepoQuotas{}, "gitService/SetRepoQuotas", nil)
 }
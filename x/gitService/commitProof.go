@@ -0,0 +1,97 @@
+package gitService
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/cosmos/cosmos-sdk/store"
+	"github.com/tendermint/tendermint/crypto/merkle"
+	tmtypes "github.com/tendermint/tendermint/types"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/idxfile"
+)
+
+// ObjectPacksResponse is the response of the objectPacks query route
+type ObjectPacksResponse struct {
+	URI    string `json:"uri"`
+	Object string `json:"object"`
+	// Packs are the checksums of the packfiles whose indexes contain the object
+	Packs []string `json:"packs"`
+}
+
+// CommitProof proves that an object, e.g. a commit, was stored in a repository at a height.
+// The index of the packfile holding the object is proven against the app hash in the header of
+// the next block, which the validators signed. It can be verified offline, trusting only the
+// validator set.
+type CommitProof struct {
+	ChainID string `json:"chain_id"`
+	URI     string `json:"uri"`
+	Object  string `json:"object"`
+	// Pack is the checksum of the packfile holding the object
+	Pack string `json:"pack"`
+	// Height is the height the packfile first got stored at
+	Height int64 `json:"height"`
+	// Index is the index of the packfile, as stored
+	Index []byte `json:"index"`
+	// Proof is the merkle proof of the index against the app hash
+	Proof *merkle.Proof `json:"proof"`
+	// SignedHeader is the header of the block after Height, holding the app hash, with the
+	// validators' signatures
+	SignedHeader tmtypes.SignedHeader `json:"signed_header"`
+	// Validators is the validator set that signed the header
+	Validators []*tmtypes.Validator `json:"validators"`
+}
+
+// ValidatorsHash returns the hash of the validator set that signed the proof, which is what
+// verifiers need to trust
+func (p CommitProof) ValidatorsHash() []byte {
+	return tmtypes.NewValidatorSet(p.Validators).Hash()
+}
+
+// Verify verifies the proof: that the validators signed the header, that the header's app hash
+// proves the packfile index, and that the index contains the object
+func (p CommitProof) Verify() error {
+	sh := p.SignedHeader
+	if err := sh.ValidateBasic(p.ChainID); err != nil {
+		return err
+	}
+	if sh.Height != p.Height+1 {
+		return fmt.Errorf("Header is of height %d rather than %d", sh.Height, p.Height+1)
+	}
+	vals := tmtypes.NewValidatorSet(p.Validators)
+	if !bytes.Equal(vals.Hash(), sh.ValidatorsHash) {
+		return fmt.Errorf("Validator set doesn't match the header")
+	}
+	if err := vals.VerifyCommit(p.ChainID, sh.Commit.BlockID, sh.Height, sh.Commit); err != nil {
+		return err
+	}
+
+	pack := plumbing.NewHash(p.Pack)
+	object := plumbing.NewHash(p.Object)
+	if pack.String() != p.Pack || object.String() != p.Object {
+		return fmt.Errorf("Invalid packfile or object hash")
+	}
+	keyPath := merkle.KeyPath{}.AppendKey([]byte(StoreKey), merkle.KeyEncodingURL).
+		AppendKey(IndexKey(p.URI, pack), merkle.KeyEncodingURL).String()
+	err := store.DefaultProofRuntime().VerifyValue(p.Proof, sh.AppHash, keyPath, p.Index)
+	if err != nil {
+		return fmt.Errorf("Proof of packfile index doesn't verify: %s", err)
+	}
+
+	idx := idxfile.NewMemoryIndex()
+	if err := idxfile.NewDecoder(bytes.NewReader(p.Index)).Decode(idx); err != nil {
+		return err
+	}
+	if plumbing.Hash(idx.PackfileChecksum) != pack {
+		return fmt.Errorf("Index is of packfile %x rather than %s", idx.PackfileChecksum, pack)
+	}
+	found, err := idx.Contains(object)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("Packfile %s doesn't contain object %s", pack, object)
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+package gitService
+
+import (
+	"fmt"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// gitService errors reserve 100 ~ 199.
+const (
+	// DefaultCodespace is the codespace of gitService errors
+	DefaultCodespace sdk.CodespaceType = "gitService"
+
+	// Error codes
+	CodeInvalidURI     sdk.CodeType = 101
+	CodeRefExists      sdk.CodeType = 102
+	CodeRefMissing     sdk.CodeType = 103
+	CodeStaleOld       sdk.CodeType = 104
+	CodeNonFastForward sdk.CodeType = 105
+	CodeUnauthorized   sdk.CodeType = 106
+	CodePackCorrupt    sdk.CodeType = 107
+	CodeMissingObjects sdk.CodeType = 108
+	CodeQuotaExceeded  sdk.CodeType = 109
+	CodeRefNotAllowed  sdk.CodeType = 110
+	CodeRepoExists     sdk.CodeType = 111
+	CodeRepoMissing    sdk.CodeType = 112
+	CodeInvalidReflog  sdk.CodeType = 113
+	CodeInvalidRepo    sdk.CodeType = 114
+)
+
+// codeToDefaultMsg returns the default message of an error code
+func codeToDefaultMsg(code sdk.CodeType) string {
+	switch code {
+	case CodeInvalidURI:
+		return "invalid repository URI"
+	case CodeRefExists:
+		return "reference already exists"
+	case CodeRefMissing:
+		return "reference doesn't exist"
+	case CodeStaleOld:
+		return "reference has changed"
+	case CodeNonFastForward:
+		return "update isn't a fast-forward"
+	case CodeUnauthorized:
+		return "unauthorized"
+	case CodePackCorrupt:
+		return "packfile is corrupt"
+	case CodeMissingObjects:
+		return "objects are missing"
+	case CodeQuotaExceeded:
+		return "quota exceeded"
+	case CodeRefNotAllowed:
+		return "reference name isn't allowed"
+	case CodeRepoExists:
+		return "repository already exists"
+	case CodeRepoMissing:
+		return "repository doesn't exist"
+	case CodeInvalidReflog:
+		return "reflog entry can't be restored"
+	case CodeInvalidRepo:
+		return "invalid repository metadata"
+	default:
+		return sdk.CodeToDefaultMsg(code)
+	}
+}
+
+// GitStatus returns the status reported to Git for a reference that didn't get updated due to
+// an error code. Git recognizes some of the statuses, e.g. giving advice on "fetch first".
+func GitStatus(code sdk.CodeType) string {
+	switch code {
+	case CodeRefExists:
+		return "already exists"
+	case CodeRefMissing:
+		return "doesn't exist"
+	case CodeStaleOld:
+		return "fetch first"
+	case CodeNonFastForward:
+		return "non-fast-forward"
+	case CodeUnauthorized:
+		return "permission denied"
+	case CodePackCorrupt:
+		return "unpacker error"
+	case CodeMissingObjects:
+		return "missing necessary objects"
+	case CodeQuotaExceeded:
+		return "quota exceeded"
+	case CodeRefNotAllowed:
+		return "reference not allowed"
+	default:
+		return codeToDefaultMsg(code)
+	}
+}
+
+//----------------------------------------
+// Error constructors
+
+// ErrInvalidURI is the error that a repository URI is invalid
+func ErrInvalidURI(codespace sdk.CodespaceType, uri string) sdk.Error {
+	return newError(codespace, CodeInvalidURI, fmt.Sprintf("Invalid repository URI: '%s'", uri))
+}
+
+// ErrUnauthorized is the error that an account isn't authorized to do something
+func ErrUnauthorized(codespace sdk.CodespaceType, msg string) sdk.Error {
+	return newError(codespace, CodeUnauthorized, msg)
+}
+
+// ErrPackCorrupt is the error that a packfile can't be stored
+func ErrPackCorrupt(codespace sdk.CodespaceType, msg string) sdk.Error {
+	return newError(codespace, CodePackCorrupt, msg)
+}
+
+// ErrMissingObjects is the error that objects that are referenced aren't stored
+func ErrMissingObjects(codespace sdk.CodespaceType, msg string) sdk.Error {
+	return newError(codespace, CodeMissingObjects, msg)
+}
+
+// ErrQuotaExceeded is the error that a quota would be exceeded
+func ErrQuotaExceeded(codespace sdk.CodespaceType, msg string) sdk.Error {
+	return newError(codespace, CodeQuotaExceeded, msg)
+}
+
+// ErrRefNotAllowed is the error that a reference name isn't allowed by the module parameters
+func ErrRefNotAllowed(codespace sdk.CodespaceType, msg string) sdk.Error {
+	return newError(codespace, CodeRefNotAllowed, msg)
+}
+
+// ErrRepoExists is the error that a repository to create already exists
+func ErrRepoExists(codespace sdk.CodespaceType, uri string) sdk.Error {
+	return newError(codespace, CodeRepoExists, fmt.Sprintf("Repository already exists: '%s'", uri))
+}
+
+// ErrRepoMissing is the error that a repository doesn't exist
+func ErrRepoMissing(codespace sdk.CodespaceType, msg string) sdk.Error {
+	return newError(codespace, CodeRepoMissing, msg)
+}
+
+// ErrInvalidReflog is the error that a reflog entry doesn't exist or can't be restored
+func ErrInvalidReflog(codespace sdk.CodespaceType, msg string) sdk.Error {
+	return newError(codespace, CodeInvalidReflog, msg)
+}
+
+// ErrInvalidRepo is the error that the metadata of a repository to create is invalid
+func ErrInvalidRepo(codespace sdk.CodespaceType, msg string) sdk.Error {
+	return newError(codespace, CodeInvalidRepo, msg)
+}
+
+//----------------------------------------
+
+// errorMessage gets the message of an error, without the codespace and code
+func errorMessage(err sdk.Error) string {
+	return fmt.Sprint(err.Data())
+}
+
+// msgOrDefaultMsg returns msg, or the default message of an error code if it's empty
+func msgOrDefaultMsg(msg string, code sdk.CodeType) string {
+	if msg != "" {
+		return msg
+	}
+	return codeToDefaultMsg(code)
+}
+
+// newError makes an error with a code, and the default message if msg is empty
+func newError(codespace sdk.CodespaceType, code sdk.CodeType, msg string) sdk.Error {
+	msg = msgOrDefaultMsg(msg, code)
+	// The message isn't a format string, as it may hold e.g. a repository URI
+	return sdk.NewError(codespace, code, "%s", msg)
+}
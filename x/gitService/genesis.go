@@ -0,0 +1,71 @@
+package gitService
+
+import (
+	"fmt"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// GenesisState is the gitService state at the start of the chain
+type GenesisState struct {
+	// RequireExplicitCreation says that repositories must be created with MsgCreateRepository
+	// before they can be pushed to, rather than getting created by the first push
+	RequireExplicitCreation bool `json:"require_explicit_creation"`
+	// Admins are accounts that administer all repositories, e.g. restoring references
+	Admins []sdk.AccAddress `json:"admins"`
+	// Params are the module parameters
+	Params Params `json:"params"`
+	// Repositories are the repositories with all their data, so that they survive a chain
+	// migration by exporting and importing the state
+	Repositories []GenesisRepository `json:"repositories"`
+}
+
+// DefaultGenesisState returns the default gitService genesis state
+func DefaultGenesisState() GenesisState {
+	return GenesisState{
+		Params: DefaultParams(),
+	}
+}
+
+// ValidateGenesis checks that a gitService genesis state is valid
+func ValidateGenesis(data GenesisState) error {
+	if err := data.Params.Validate(); err != nil {
+		return err
+	}
+
+	uris := make(map[string]bool)
+	for _, repo := range data.Repositories {
+		if err := repo.validate(); err != nil {
+			return err
+		}
+		if uris[repo.Repository.URI] {
+			return fmt.Errorf("Repository '%s' appears twice", repo.Repository.URI)
+		}
+		uris[repo.Repository.URI] = true
+	}
+
+	return nil
+}
+
+// InitGenesis initializes the gitService state from genesis, which must have been validated
+func InitGenesis(ctx sdk.Context, keeper Keeper, data GenesisState) error {
+	keeper.SetRequireExplicitCreation(ctx, data.RequireExplicitCreation)
+	keeper.SetAdmins(ctx, data.Admins)
+	keeper.SetParams(ctx, data.Params)
+	return keeper.ImportRepositories(ctx, data.Repositories)
+}
+
+// ExportGenesis exports the gitService state as genesis
+func ExportGenesis(ctx sdk.Context, keeper Keeper) (GenesisState, error) {
+	repos, err := keeper.ExportRepositories(ctx)
+	if err != nil {
+		return GenesisState{}, err
+	}
+
+	return GenesisState{
+		RequireExplicitCreation: keeper.RequiresExplicitCreation(ctx),
+		Admins:                  keeper.GetAdmins(ctx),
+		Params:                  keeper.GetParams(ctx),
+		Repositories:            repos,
+	}, nil
+}
@@ -11,10 +11,18 @@ import (
 func NewHandler(keeper Keeper) sdk.Handler {
 	return func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
 		switch msg := msg.(type) {
+		case MsgCreateRepository:
+			return handleMsgCreateRepository(ctx, keeper, msg)
 		case MsgUpdateReferences:
 			return handleMsgUpdateReferences(ctx, keeper, msg)
 		case MsgRemoveRepository:
 			return handleMsgRemoveRepository(ctx, keeper, msg)
+		case MsgSetSymbolicReference:
+			return handleMsgSetSymbolicReference(ctx, keeper, msg)
+		case MsgRestoreReference:
+			return handleMsgRestoreReference(ctx, keeper, msg)
+		case MsgSetRepoQuotas:
+			return handleMsgSetRepoQuotas(ctx, keeper, msg)
 		default:
 			errMsg := fmt.Sprintf("Unrecognized gitService Msg type: %v", msg.Type())
 			return sdk.ErrUnknownRequest(errMsg).Result()
@@ -22,28 +30,91 @@ func NewHandler(keeper Keeper) sdk.Handler {
 	}
 }
 
+func handleMsgCreateRepository(ctx sdk.Context, keeper Keeper, msg MsgCreateRepository) sdk.Result {
+	log.Debug().Msgf("Handling MsgCreateRepository - author: '%s', repo: '%s'",
+		msg.Author, msg.URI)
+	if err := keeper.CreateRepository(ctx, msg); err != nil {
+		return errorResult(err)
+	}
+
+	return sdk.Result{
+		Tags: repoTags(msg.URI, msg.Author),
+	}
+}
+
 func handleMsgUpdateReferences(ctx sdk.Context, keeper Keeper, msg MsgUpdateReferences) sdk.Result {
 	log.Debug().Msgf("Handling MsgUpdateReferences - author: '%s', repo: '%s'",
 		msg.Author, msg.URI)
-	if err := keeper.UpdateReferences(ctx, msg); err != nil {
-		return sdk.Result{
-			Code: err.Code(),
-			Data: []byte(err.Error()),
-		}
+	report, tags, err := keeper.UpdateReferences(ctx, msg)
+	if err != nil {
+		result := errorResult(err)
+		result.Data = encodePushReport(keeper.cdc, report)
+		return result
 	}
 
-	return sdk.Result{}
+	return sdk.Result{
+		Data: encodePushReport(keeper.cdc, report),
+		Tags: tags,
+	}
 }
 
 func handleMsgRemoveRepository(ctx sdk.Context, keeper Keeper, msg MsgRemoveRepository) sdk.Result {
 	log.Debug().Msgf("Handling MsgRemoveRepo - author: '%s', repo: '%s'",
 		msg.Author, msg.URI)
 	if err := keeper.RemoveRepository(ctx, msg); err != nil {
-		return sdk.Result{
-			Code: err.Code(),
-			Data: []byte(err.Error()),
-		}
+		return errorResult(err)
+	}
+
+	return sdk.Result{
+		Tags: repoTags(msg.URI, msg.Author),
+	}
+}
+
+func handleMsgSetSymbolicReference(ctx sdk.Context, keeper Keeper,
+	msg MsgSetSymbolicReference) sdk.Result {
+	log.Debug().Msgf("Handling MsgSetSymbolicReference - author: '%s', repo: '%s'",
+		msg.Author, msg.URI)
+	if err := keeper.SetSymbolicReference(ctx, msg); err != nil {
+		return errorResult(err)
 	}
 
-	return sdk.Result{}
+	return sdk.Result{
+		Tags: repoTags(msg.URI, msg.Author).AppendTag(TagRef, []byte(msg.Name)),
+	}
+}
+
+func handleMsgRestoreReference(ctx sdk.Context, keeper Keeper,
+	msg MsgRestoreReference) sdk.Result {
+	log.Debug().Msgf("Handling MsgRestoreReference - author: '%s', repo: '%s', reference: '%s'",
+		msg.Author, msg.URI, msg.Name)
+	tags, err := keeper.RestoreReference(ctx, msg)
+	if err != nil {
+		return errorResult(err)
+	}
+
+	return sdk.Result{
+		Tags: tags,
+	}
+}
+
+func handleMsgSetRepoQuotas(ctx sdk.Context, keeper Keeper, msg MsgSetRepoQuotas) sdk.Result {
+	log.Debug().Msgf("Handling MsgSetRepoQuotas - author: '%s', repo: '%s'",
+		msg.Author, msg.URI)
+	if err := keeper.SetRepoQuotas(ctx, msg); err != nil {
+		return errorResult(err)
+	}
+
+	return sdk.Result{
+		Tags: repoTags(msg.URI, msg.Author),
+	}
+}
+
+// errorResult makes the result of a message that failed with an error
+func errorResult(err sdk.Error) sdk.Result {
+	return sdk.Result{
+		Code:      err.Code(),
+		Codespace: err.Codespace(),
+		Data:      []byte(err.Error()),
+		Log:       err.ABCILog(),
+	}
 }
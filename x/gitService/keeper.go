@@ -1,12 +1,13 @@
 package gitService
 
 import (
-	"errors"
 	"fmt"
 	"regexp"
 	"strings"
 
 	"github.com/cosmos/cosmos-sdk/codec"
+	"github.com/cosmos/cosmos-sdk/x/auth"
+	"github.com/cosmos/cosmos-sdk/x/params"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
@@ -15,50 +16,121 @@ import (
 	sdk "github.com/cosmos/cosmos-sdk/types"
 )
 
-var reRepoURI = regexp.MustCompile("^[^/]+/[^/]+$")
+// StoreKey is the name of the store holding the Git data, which clients query with proofs
+const StoreKey = "git"
+
+var (
+	reRepoURI = regexp.MustCompile("^[^/]+/[^/]+$")
+	// keyRequireExplicitCreation is the store key of the setting whether repositories must be
+	// created explicitly. Keys starting with a slash can't collide with repository keys.
+	keyRequireExplicitCreation = []byte("/settings/requireExplicitCreation")
+	// keyAdmins is the store key of the accounts that administer all repositories
+	keyAdmins = []byte("/settings/admins")
+)
 
 // Keeper maintains the link to data storage and exposes getter/setter methods for the various
 // parts of the state machine
 type Keeper struct {
-	gitStoreKey sdk.StoreKey
+	gitStoreKey         sdk.StoreKey
+	accountKeeper       auth.AccountKeeper
+	feeCollectionKeeper auth.FeeCollectionKeeper
+	paramSpace          params.Subspace
 
 	cdc *codec.Codec // The wire codec for binary encoding/decoding.
+
+	// codespace is the codespace of the errors returned
+	codespace sdk.CodespaceType
 }
 
 // NewKeeper creates new instances of the gitService Keeper
-func NewKeeper(gitStoreKey sdk.StoreKey, cdc *codec.Codec) Keeper {
+func NewKeeper(gitStoreKey sdk.StoreKey, accountKeeper auth.AccountKeeper,
+	feeCollectionKeeper auth.FeeCollectionKeeper, paramSpace params.Subspace, cdc *codec.Codec,
+	codespace sdk.CodespaceType) Keeper {
 	return Keeper{
-		gitStoreKey: gitStoreKey,
-		cdc:         cdc,
+		gitStoreKey:         gitStoreKey,
+		accountKeeper:       accountKeeper,
+		feeCollectionKeeper: feeCollectionKeeper,
+		paramSpace:          paramSpace.WithTypeTable(ParamTypeTable()),
+		cdc:                 cdc,
+		codespace:           codespace,
 	}
 }
 
-// ListRefs lists refs for a repository
-func (k Keeper) ListRefs(ctx sdk.Context, owner string, repo string) []string {
-	// store := ctx.KVStore(k.gitStoreKey)
-	// return string(store.Get([]byte(url)))
-	return []string{}
+// ListRefs lists refs for a repository matching a query, on the format of the Git remote helper
+// list command (i.e. "<hash> <name>", or "@<target> <name>" for symbolic references). When
+// peeling, annotated tags get a "peeled:<hash>" attribute.
+func (k Keeper) ListRefs(ctx sdk.Context, owner string, repo string, query RefsQuery) (
+	*ListRefsResponse, error) {
+	uri := fmt.Sprintf("%s/%s", owner, repo)
+	log.Debug().Msgf("Keeper listing references of repo '%s', query: %+v", uri, query)
+	store := ctx.KVStore(k.gitStoreKey)
+	peeler := &refPeeler{store: store, uri: uri}
+	resp := &ListRefsResponse{Refs: []string{}}
+	next, err := iterRefs(store, uri, query, func(name plumbing.ReferenceName, value string) error {
+		ref := plumbing.NewReferenceFromStrings(name.String(), value)
+		if ref.Type() == plumbing.SymbolicReference {
+			if query.Symrefs {
+				resp.Refs = append(resp.Refs, fmt.Sprintf("@%s %s", ref.Target(), name))
+			} else if h := resolveRef(store, uri, name, value); !h.IsZero() {
+				resp.Refs = append(resp.Refs, fmt.Sprintf("%s %s", h, name))
+			}
+			return nil
+		}
+
+		line := fmt.Sprintf("%s %s", ref.Hash(), name)
+		if query.Peel {
+			peeled, ok, err := peeler.peel(name, ref.Hash())
+			if err != nil {
+				return err
+			}
+			if ok {
+				line = fmt.Sprintf("%s peeled:%s", line, peeled)
+			}
+		}
+		resp.Refs = append(resp.Refs, line)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp.Next = next
+
+	return resp, nil
+}
+
+// ListPackfiles lists the hashes of packfiles stored for a repository
+func (k Keeper) ListPackfiles(ctx sdk.Context, owner string, repo string) ([]plumbing.Hash,
+	error) {
+	uri := fmt.Sprintf("%s/%s", owner, repo)
+	log.Debug().Msgf("Keeper listing packfiles of repo '%s'", uri)
+	return listPackfiles(ctx.KVStore(k.gitStoreKey), uri)
+}
+
+// GetPackfile gets the contents of a packfile stored for a repository, or nil if it doesn't exist
+func (k Keeper) GetPackfile(ctx sdk.Context, owner string, repo string, hash plumbing.Hash) []byte {
+	path := fmt.Sprintf("%s/%s/objects/pack/pack-%s.pack", owner, repo, hash)
+	log.Debug().Msgf("Keeper getting packfile '%s'", path)
+	return ctx.KVStore(k.gitStoreKey).Get([]byte(path))
 }
 
-// GetAdvertisedReferences gets advertised references for a repository
-func (k Keeper) GetAdvertisedReferences(ctx sdk.Context, owner string, repo string) (
-	*packp.AdvRefs, error) {
+// GetAdvertisedReferences gets advertised references for a repository matching a query
+func (k Keeper) GetAdvertisedReferences(ctx sdk.Context, owner string, repo string,
+	query RefsQuery) (*AdvertisedReferencesResponse, error) {
 	uri := fmt.Sprintf("%s/%s", owner, repo)
-	log.Debug().Msgf("Keeper getting advertised references for repo '%s'", uri)
+	log.Debug().Msgf("Keeper getting advertised references for repo '%s', query: %+v", uri,
+		query)
 	ar := packp.NewAdvRefs()
 	if err := setSupportedCapabilities(ar.Capabilities); err != nil {
 		return nil, err
 	}
 
 	store := ctx.KVStore(k.gitStoreKey)
-	if err := setReferences(store, ar, uri); err != nil {
-		return nil, err
-	}
-	if err := setHead(store, ar, uri); err != nil {
+	next, err := setReferences(store, ar, uri, query)
+	if err != nil {
 		return nil, err
 	}
 
-	return ar, nil
+	return &AdvertisedReferencesResponse{AdvRefs: ar, Next: next}, nil
 }
 
 func setSupportedCapabilities(c *capability.List) error {
@@ -77,106 +149,668 @@ func setSupportedCapabilities(c *capability.List) error {
 	return c.Set(capability.ReportStatus)
 }
 
-func setReferences(store sdk.KVStore, ar *packp.AdvRefs, uri string) error {
-	iter := store.Iterator(nil, nil)
-	defer iter.Close()
-	for ; iter.Valid(); iter.Next() {
-		key := string(iter.Key())
-		// TODO: Define which references should be included
-		if strings.HasPrefix(key, fmt.Sprintf("%s/refs/", uri)) {
-			refName := key[len(uri)+1:]
-			hashBytes := store.Get([]byte(key))
-			if hashBytes == nil {
-				return fmt.Errorf("Couldn't get hash for reference '%s'", key)
+// setReferences adds the references matching a query to advertised references, returning the
+// cursor for the next page
+func setReferences(store sdk.KVStore, ar *packp.AdvRefs, uri string, query RefsQuery) (
+	string, error) {
+	peeler := &refPeeler{store: store, uri: uri}
+	return iterRefs(store, uri, query, func(name plumbing.ReferenceName, value string) error {
+		if name == plumbing.HEAD {
+			return setHead(store, ar, uri, value, query.Symrefs)
+		}
+
+		ref := plumbing.NewReferenceFromStrings(name.String(), value)
+		if ref.Type() == plumbing.SymbolicReference {
+			if query.Symrefs {
+				if err := ar.AddReference(ref); err != nil {
+					return err
+				}
+			}
+			if h := resolveRef(store, uri, name, value); !h.IsZero() {
+				ar.References[name.String()] = h
 			}
-			hash := string(hashBytes)
+			return nil
+		}
+
+		hash := ref.Hash()
+		log.Debug().Msgf("Keeper adding reference '%s' -> '%s' to advertised references", name,
+			hash)
+		ar.References[name.String()] = hash
+		if !query.Peel {
+			return nil
+		}
+
+		peeled, ok, err := peeler.peel(name, hash)
+		if err != nil {
+			return err
+		}
+		if ok {
+			ar.Peeled[name.String()] = peeled
+		}
+		return nil
+	})
+}
 
-			log.Debug().Msgf("Keeper adding reference '%s' -> '%s' to advertised references", refName, hash)
-			ar.References[refName] = plumbing.NewHash(string(hash))
+// setHead sets the head of advertised references, and if requested the symref capability for
+// a symbolic head
+func setHead(store sdk.KVStore, ar *packp.AdvRefs, uri string, value string,
+	symrefs bool) error {
+	ref := plumbing.NewReferenceFromStrings("HEAD", value)
+	if ref.Type() == plumbing.SymbolicReference && symrefs {
+		log.Debug().Msgf("Repository head reference is symbolic, target: '%s'", ref.Target())
+		if err := ar.AddReference(ref); err != nil {
+			return err
 		}
 	}
 
+	h := resolveRef(store, uri, plumbing.HEAD, value)
+	if h.IsZero() {
+		log.Debug().Msgf("Repository head is unborn")
+		return nil
+	}
+
+	ar.Head = &h
+	log.Debug().Msgf("Determined repo head: '%s'", ar.Head)
+
 	return nil
 }
 
-func setHead(store sdk.KVStore, ar *packp.AdvRefs, uri string) error {
-	headPath := fmt.Sprintf("%s/HEAD", uri)
-	log.Debug().Msgf("Keeper determining repository head, path: '%s'", headPath)
-	refBytes := store.Get([]byte(headPath))
-	if refBytes == nil {
-		log.Debug().Msgf("Repository doesn't have head")
-		return nil
+// UpdateReferences updates a set of Git references, returning a report of the outcome, also on
+// failure, and tags for the changes
+func (k Keeper) UpdateReferences(ctx sdk.Context, msg MsgUpdateReferences) (*PushReport,
+	sdk.Tags, sdk.Error) {
+	report := newPushReport(msg)
+	fail := func(err sdk.Error) (*PushReport, sdk.Tags, sdk.Error) {
+		report.fail(err)
+		return report, nil, err
 	}
-	refStr := string(refBytes)
 
-	ref := plumbing.NewReferenceFromStrings("HEAD", refStr)
-	if ref.Type() == plumbing.SymbolicReference {
-		log.Debug().Msgf("Repository head reference is symbolic, target: '%s'", refStr)
-		if err := ar.AddReference(ref); err != nil {
-			return nil
+	if !reRepoURI.MatchString(msg.URI) {
+		log.Debug().Msgf("Invalid repo URI: '%s'", msg.URI)
+		return fail(ErrInvalidURI(k.codespace, msg.URI))
+	}
+
+	log.Debug().Msgf("Keeper updating references in repo '%s', push options: %v", msg.URI,
+		msg.PushOptions)
+	// TODO: Verify that user is authorized to write to repo
+	// The bytes written are measured for the storage deposit
+	storage := &storageStore{KVStore: ctx.KVStore(k.gitStoreKey)}
+	store := sdk.KVStore(storage)
+	if !store.Has(headKey(msg.URI)) {
+		if k.RequiresExplicitCreation(ctx) {
+			log.Debug().Msgf("Repo '%s' doesn't exist and must be created explicitly", msg.URI)
+			return fail(ErrRepoMissing(k.codespace, fmt.Sprintf(
+				"Repository '%s' doesn't exist, it must be created before pushing", msg.URI)))
 		}
 
-		// Get target reference
-		headPath = fmt.Sprintf("%s/%s", uri, ref.Target())
-		log.Debug().Msgf("Keeper getting repository head reference, path: '%s'", headPath)
-		refBytes = store.Get([]byte(headPath))
-		if refBytes == nil {
-			log.Debug().Msgf("Failed to get the contents of head reference at '%s'", headPath)
-			return nil
+		repo := Repository{
+			URI:        msg.URI,
+			Owner:      msg.Author,
+			Visibility: VisibilityPublic,
+		}
+		if err := k.chargeRepoCreationFee(ctx, repo); err != nil {
+			return fail(err)
+		}
+		if err := k.initializeRepo(ctx, store, repo, DefaultBranchForPush(msg)); err != nil {
+			return fail(sdk.ErrInternal(err.Error()))
+		}
+	}
+	if !isSearchIndexed(store, msg.URI) {
+		// The repository predates the search index
+		repo, err := k.getRepository(store, msg.URI)
+		if err != nil {
+			return fail(sdk.ErrInternal(err.Error()))
 		}
-		refStr = string(refBytes)
+		indexRepoSearch(store, *repo)
+	}
+	stats, err := getRepoStats(store, k.cdc, msg.URI)
+	if err != nil {
+		return fail(sdk.ErrInternal(err.Error()))
+	}
 
-		ref = plumbing.NewReferenceFromStrings(ref.Target().String(), refStr)
+	params := k.GetParams(ctx)
+	for i, cmd := range msg.Commands {
+		if cmd.Action() != packp.Delete && !params.isRefAllowed(cmd.Name) {
+			log.Debug().Msgf("Reference '%s' isn't allowed", cmd.Name)
+			cmdErr := &commandError{index: i, name: cmd.Name, code: CodeRefNotAllowed,
+				msg: fmt.Sprintf("only references starting with %s are allowed",
+					strings.Join(params.AllowedRefPrefixes, ", "))}
+			report.failCommand(cmdErr)
+			return report, nil, newError(k.codespace, cmdErr.code, cmdErr.Error())
+		}
+	}
+	quotas, _ := effectiveQuotas(store, k.cdc, params, msg.URI)
+	if err := checkQuota(k.codespace, "Packfile size", int64(len(msg.Packfile)),
+		quotas.MaxPackSize); err != nil {
+		return fail(err)
+	}
+	// The packfile is the bulk of what a push writes, so check the repository size up front
+	// rather than burning gas writing everything first. The exact check follows the writes.
+	if err := checkQuota(k.codespace, "Repository size", stats.Storage+int64(len(msg.Packfile)),
+		quotas.MaxRepoSize); err != nil {
+		return fail(err)
+	}
+	consumeGas(ctx, params.GasPerByte, int64(len(msg.Packfile)), "packfile bytes")
+	pack, err := writePackfile(store, msg)
+	if err != nil {
+		log.Debug().Msgf("Storing packfile failed: %s", err)
+		sdkErr := ErrPackCorrupt(k.codespace, err.Error())
+		report.failUnpack(sdkErr)
+		return report, nil, sdkErr
+	}
+	report.PackID, report.Objects = pack.checksum, pack.objects
+	if err := checkQuota(k.codespace, "Object size", pack.maxObjectSize,
+		quotas.MaxObjectSize); err != nil {
+		report.failUnpack(err)
+		return report, nil, err
+	}
+	consumeGas(ctx, params.GasPerObject, report.Objects, "packfile objects")
+	consumeGas(ctx, params.GasPerRef, int64(len(msg.Commands)), "references")
+
+	olds := make([]plumbing.Hash, len(msg.Commands))
+	for i, cmd := range msg.Commands {
+		refPath := fmt.Sprintf("%s/%s", msg.URI, cmd.Name)
+		if value := store.Get([]byte(refPath)); value != nil {
+			olds[i] = resolveRef(store, msg.URI, cmd.Name, string(value))
+		}
+	}
+	refDelta, cmdErr := updateReferences(store, msg, olds)
+	if cmdErr != nil {
+		report.failCommand(cmdErr)
+		return report, nil, newError(k.codespace, cmdErr.code, cmdErr.Error())
+	}
+	if refDelta > 0 {
+		if err := checkQuota(k.codespace, "Reference count", stats.RefCount+refDelta,
+			quotas.MaxRefs); err != nil {
+			return fail(err)
+		}
+	}
+	tags := repoTags(msg.URI, msg.Author)
+	for i, cmd := range msg.Commands {
+		if err := appendReflog(ctx, store, k.cdc, msg.URI, cmd.Name, olds[i], cmd.New,
+			msg.Author); err != nil {
+			return fail(sdk.ErrInternal(err.Error()))
+		}
+		tags = tags.AppendTags(refTags(cmd.Name.String(), olds[i].String(), cmd.New.String()))
 	}
 
-	if ref.Type() != plumbing.HashReference {
-		return plumbing.ErrInvalidType
+	if err := writePeeledTags(store, msg); err != nil {
+		if cmdErr, ok := err.(*commandError); ok {
+			report.failCommand(cmdErr)
+			return report, nil, newError(k.codespace, cmdErr.code, cmdErr.Error())
+		}
+		return fail(sdk.ErrInternal(err.Error()))
 	}
 
-	h := ref.Hash()
-	ar.Head = &h
-	log.Debug().Msgf("Determined repo head: '%s'", ar.Head)
+	if err := k.chargeStorage(ctx, store, msg.URI, msg.Author, stats.Storage, storage.delta,
+		params.DepositPerKiB, quotas); err != nil {
+		return fail(err)
+	}
+
+	stats.Size += int64(len(msg.Packfile))
+	stats.RefCount += refDelta
+	stats.LastPushHeight = ctx.BlockHeight()
+	stats.Storage += storage.delta
+	if err := setRepoStats(store, k.cdc, msg.URI, stats); err != nil {
+		return fail(sdk.ErrInternal(err.Error()))
+	}
+
+	return report, tags, nil
+}
 
+// chargeStorage checks the bytes written to a repository against its size quota, given the
+// bytes it took up before, and takes the storage deposit for them from the author. If the
+// repository shrank, the author's deposit gets refunded for the bytes freed, up to all of it.
+func (k Keeper) chargeStorage(ctx sdk.Context, store sdk.KVStore, uri string,
+	author sdk.AccAddress, storage int64, delta int64, depositPerKiB sdk.Coins,
+	quotas Quotas) sdk.Error {
+	if delta < 0 {
+		_, err := refundDeposit(ctx, store, k.cdc, k.accountKeeper, uri, author,
+			storageRefund(depositPerKiB, -delta))
+		return err
+	}
+	if delta == 0 {
+		return nil
+	}
+
+	if err := checkQuota(k.codespace, "Repository size", storage+delta,
+		quotas.MaxRepoSize); err != nil {
+		return err
+	}
+	deposit := storageDeposit(depositPerKiB, delta)
+	if deposit.IsZero() {
+		return nil
+	}
+	return takeDeposit(ctx, store, k.cdc, k.accountKeeper, uri, author, deposit)
+}
+
+// chargeRepoCreationFee charges the fee for creating a repository to its owner, adding it to
+// the fees collected
+func (k Keeper) chargeRepoCreationFee(ctx sdk.Context, repo Repository) sdk.Error {
+	fee := k.GetParams(ctx).RepoCreationFee
+	if fee.IsZero() {
+		return nil
+	}
+
+	log.Debug().Msgf("Charging creation fee %s for repo '%s' to %s", fee, repo.URI, repo.Owner)
+	if err := subtractCoins(ctx, k.accountKeeper, repo.Owner, fee,
+		"Repository creation fee"); err != nil {
+		return err
+	}
+	k.feeCollectionKeeper.AddCollectedFees(ctx, fee)
 	return nil
 }
 
-// UpdateReferences updates a set of Git references
-func (k Keeper) UpdateReferences(ctx sdk.Context, msg MsgUpdateReferences) sdk.Error {
+// initializeRepo initializes a repository with metadata and HEAD pointing to a default branch
+func (k Keeper) initializeRepo(ctx sdk.Context, store sdk.KVStore, repo Repository,
+	defaultBranch plumbing.ReferenceName) error {
+	log.Debug().Msgf("Keeper - store doesn't have repo '%s', initializing it", repo.URI)
+	repo.CreatedAt = ctx.BlockHeight()
+	// The default branch is derived from HEAD
+	repo.DefaultBranch = ""
+	bz, err := k.cdc.MarshalBinaryBare(repo)
+	if err != nil {
+		return err
+	}
+	store.Set(metadataKey(repo.URI), bz)
+	if err := setRepoStats(store, k.cdc, repo.URI, RepositoryStats{}); err != nil {
+		return err
+	}
+	indexRepoSearch(store, repo)
+
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, defaultBranch)
+	writeReference(store, string(headKey(repo.URI)), head)
+	store.Set([]byte(fmt.Sprintf("%s/config", repo.URI)), []byte(`[core]
+	repositoryformatversion = 0
+	bare = true
+`))
+	return nil
+}
+
+// CreateRepository creates a repository with metadata
+func (k Keeper) CreateRepository(ctx sdk.Context, msg MsgCreateRepository) sdk.Error {
 	if !reRepoURI.MatchString(msg.URI) {
 		log.Debug().Msgf("Invalid repo URI: '%s'", msg.URI)
-		return sdk.ErrUnknownRequest(fmt.Sprintf("Invalid repo URI: '%s'", msg.URI))
+		return ErrInvalidURI(k.codespace, msg.URI)
 	}
 
-	log.Debug().Msgf("Keeper updating references in repo '%s'", msg.URI)
-	// TODO: Verify that user is authorized to write to repo
-	store := ctx.KVStore(k.gitStoreKey)
-	if !store.Has([]byte(fmt.Sprintf("%s/HEAD", msg.URI))) {
-		if err := initializeRepo(store, msg); err != nil {
-			return sdk.ErrInternal(err.Error())
-		}
+	log.Debug().Msgf("Keeper creating repo '%s'", msg.URI)
+	// The bytes written are measured for the storage deposit, like for a push creating it
+	storage := &storageStore{KVStore: ctx.KVStore(k.gitStoreKey)}
+	store := sdk.KVStore(storage)
+	if store.Has(headKey(msg.URI)) {
+		return ErrRepoExists(k.codespace, msg.URI)
 	}
 
-	if err := writePackfile(store, msg); err != nil {
+	defaultBranch := msg.DefaultBranch
+	if defaultBranch == "" {
+		defaultBranch = plumbing.Master
+	}
+	visibility := msg.Visibility
+	if visibility == "" {
+		visibility = VisibilityPublic
+	}
+	repo := Repository{
+		URI:         msg.URI,
+		Owner:       msg.Author,
+		Description: msg.Description,
+		Visibility:  visibility,
+		License:     msg.License,
+		Topics:      msg.Topics,
+	}
+	if err := k.chargeRepoCreationFee(ctx, repo); err != nil {
+		return err
+	}
+	if err := k.initializeRepo(ctx, store, repo, defaultBranch); err != nil {
 		return sdk.ErrInternal(err.Error())
 	}
 
-	if err := updateReferences(store, msg); err != nil {
+	params := k.GetParams(ctx)
+	quotas, _ := effectiveQuotas(store, k.cdc, params, msg.URI)
+	if err := k.chargeStorage(ctx, store, msg.URI, msg.Author, 0, storage.delta,
+		params.DepositPerKiB, quotas); err != nil {
+		return err
+	}
+	if err := setRepoStats(store, k.cdc, msg.URI, RepositoryStats{
+		Storage: storage.delta,
+	}); err != nil {
 		return sdk.ErrInternal(err.Error())
 	}
 
 	return nil
 }
 
-func initializeRepo(store sdk.KVStore, msg MsgUpdateReferences) error {
-	log.Debug().Msgf("Keeper - store doesn't have repo '%s', initializing it", msg.URI)
-	store.Set([]byte(fmt.Sprintf("%s/HEAD", msg.URI)), []byte("ref: refs/heads/master"))
-	store.Set([]byte(fmt.Sprintf("%s/config", msg.URI)), []byte(`[core]
-	repositoryformatversion = 0
-	bare = true
-`))
+// GetRepository gets the metadata of a repository, or nil if it doesn't exist. Repositories
+// created before metadata got stored only have their URI and default branch set.
+func (k Keeper) GetRepository(ctx sdk.Context, owner string, name string) (*Repository, error) {
+	uri := fmt.Sprintf("%s/%s", owner, name)
+	log.Debug().Msgf("Keeper getting repo '%s'", uri)
+	return k.getRepository(ctx.KVStore(k.gitStoreKey), uri)
+}
+
+func (k Keeper) getRepository(store sdk.KVStore, uri string) (*Repository, error) {
+	headBytes := store.Get(headKey(uri))
+	if headBytes == nil {
+		return nil, nil
+	}
+
+	// Repositories created before metadata got introduced have none
+	repo := &Repository{URI: uri, Visibility: VisibilityPublic}
+	if bz := store.Get(metadataKey(uri)); bz != nil {
+		if err := k.cdc.UnmarshalBinaryBare(bz, repo); err != nil {
+			return nil, err
+		}
+	}
+	head := plumbing.NewReferenceFromStrings(plumbing.HEAD.String(), string(headBytes))
+	if head.Type() == plumbing.SymbolicReference {
+		repo.DefaultBranch = head.Target()
+	}
+
+	return repo, nil
+}
+
+// ListRepositories lists the repositories matching a query, along with their statistics
+func (k Keeper) ListRepositories(ctx sdk.Context, query ReposQuery) (*ListReposResponse, error) {
+	log.Debug().Msgf("Keeper listing repos, query: %+v", query)
+	store := ctx.KVStore(k.gitStoreKey)
+	var repos []RepositorySummary
+	next, err := iterRepos(store, k.cdc, query, func(uri string, stats RepositoryStats) error {
+		repo, err := k.getRepository(store, uri)
+		if err != nil {
+			return err
+		}
+		if repo == nil {
+			return fmt.Errorf("Indexed repository doesn't exist: '%s'", uri)
+		}
+
+		repos = append(repos, RepositorySummary{
+			URI:             uri,
+			Owner:           repo.Owner,
+			DefaultBranch:   repo.DefaultBranch.String(),
+			RepositoryStats: stats,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ListReposResponse{Repositories: repos, Next: next}, nil
+}
+
+// Search finds the public repositories matching a query, the most recently active first
+func (k Keeper) Search(ctx sdk.Context, query SearchQuery) ([]SearchResult, error) {
+	log.Debug().Msgf("Keeper searching repos, query: %+v", query)
+	store := ctx.KVStore(k.gitStoreKey)
+	var results []SearchResult
+	for _, uri := range searchRepos(store, query) {
+		repo, err := k.getRepository(store, uri)
+		if err != nil {
+			return nil, err
+		}
+		if repo == nil {
+			return nil, fmt.Errorf("Indexed repository doesn't exist: '%s'", uri)
+		}
+		if repo.Visibility == VisibilityPrivate || !matchesSearchText(*repo, query.Text) {
+			continue
+		}
+
+		height, err := lastActivityHeight(store, k.cdc, repo)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, SearchResult{Repository: *repo, LastActivityHeight: height})
+	}
+
+	return rankSearchResults(results, query.Limit), nil
+}
+
+// GetStorage gets the storage usage and deposits of a repository, or nil if it doesn't exist
+func (k Keeper) GetStorage(ctx sdk.Context, owner string, repo string) (*StorageResponse,
+	error) {
+	uri := fmt.Sprintf("%s/%s", owner, repo)
+	log.Debug().Msgf("Keeper getting storage of repo '%s'", uri)
+	store := ctx.KVStore(k.gitStoreKey)
+	if !store.Has(headKey(uri)) {
+		return nil, nil
+	}
+
+	stats, err := getRepoStats(store, k.cdc, uri)
+	if err != nil {
+		return nil, err
+	}
+	resp := &StorageResponse{
+		URI:      uri,
+		Storage:  stats.Storage,
+		Deposits: getDeposits(store, k.cdc, uri),
+	}
+	for _, deposit := range resp.Deposits {
+		resp.Deposit = resp.Deposit.Plus(deposit.Amount)
+	}
+
+	return resp, nil
+}
+
+// FindObject finds the packfiles of a repository whose indexes contain an object, or returns nil
+// if the repository doesn't exist
+func (k Keeper) FindObject(ctx sdk.Context, owner string, repo string, h plumbing.Hash) (
+	*ObjectPacksResponse, error) {
+	uri := fmt.Sprintf("%s/%s", owner, repo)
+	log.Debug().Msgf("Keeper finding object %s in repo '%s'", h, uri)
+	store := ctx.KVStore(k.gitStoreKey)
+	if !store.Has(headKey(uri)) {
+		return nil, nil
+	}
+
+	packs, err := objectPacks(store, uri, h)
+	if err != nil {
+		return nil, err
+	}
+	resp := &ObjectPacksResponse{URI: uri, Object: h.String(), Packs: []string{}}
+	for _, pack := range packs {
+		resp.Packs = append(resp.Packs, pack.String())
+	}
+
+	return resp, nil
+}
+
+// GetQuotas gets the quotas applying to a repository and its usage, or nil if it doesn't exist
+func (k Keeper) GetQuotas(ctx sdk.Context, owner string, repo string) (*QuotasResponse, error) {
+	uri := fmt.Sprintf("%s/%s", owner, repo)
+	log.Debug().Msgf("Keeper getting quotas of repo '%s'", uri)
+	store := ctx.KVStore(k.gitStoreKey)
+	if !store.Has(headKey(uri)) {
+		return nil, nil
+	}
+
+	stats, err := getRepoStats(store, k.cdc, uri)
+	if err != nil {
+		return nil, err
+	}
+	quotas, override := effectiveQuotas(store, k.cdc, k.GetParams(ctx), uri)
+	return &QuotasResponse{
+		URI:      uri,
+		Quotas:   quotas,
+		Override: override,
+		RepoSize: stats.Storage,
+		Refs:     stats.RefCount,
+	}, nil
+}
+
+// SetRepoQuotas sets the quotas of a repository, overriding those of the module parameters.
+// Only the accounts administering all repositories may do so, so that owners can't lift their
+// own quotas.
+func (k Keeper) SetRepoQuotas(ctx sdk.Context, msg MsgSetRepoQuotas) sdk.Error {
+	log.Debug().Msgf("Keeper setting quotas of repo '%s' to %+v", msg.URI, msg.Quotas)
+	store := ctx.KVStore(k.gitStoreKey)
+	if !store.Has(headKey(msg.URI)) {
+		return ErrRepoMissing(k.codespace, fmt.Sprintf("Repository doesn't exist: '%s'", msg.URI))
+	}
+	if !k.isGlobalAdmin(ctx, msg.Author) {
+		return ErrUnauthorized(k.codespace, fmt.Sprintf(
+			"%s doesn't administer all repositories", msg.Author))
+	}
+
+	setRepoQuotas(store, k.cdc, msg.URI, msg.Quotas)
+	return nil
+}
+
+// GetReflog lists the entries in the log of a reference matching a query, the newest first
+func (k Keeper) GetReflog(ctx sdk.Context, owner string, repo string,
+	name plumbing.ReferenceName, query ReflogQuery) (*ReflogResponse, error) {
+	uri := fmt.Sprintf("%s/%s", owner, repo)
+	log.Debug().Msgf("Keeper getting log of reference '%s' in repo '%s', query: %+v", name, uri,
+		query)
+	return listReflog(ctx.KVStore(k.gitStoreKey), k.cdc, uri, name, query)
+}
+
+// ExportRepositories exports the state of all repositories, in URI order
+func (k Keeper) ExportRepositories(ctx sdk.Context) ([]GenesisRepository, error) {
+	log.Debug().Msgf("Keeper exporting repositories")
+	store := ctx.KVStore(k.gitStoreKey)
+	// Repositories predating the repository index aren't in it, so look for HEADs instead
+	var uris []string
+	iter := store.Iterator(nil, nil)
+	for ; iter.Valid(); iter.Next() {
+		if key := string(iter.Key()); reHeadKey.MatchString(key) {
+			uris = append(uris, strings.TrimSuffix(key, "/HEAD"))
+		}
+	}
+	iter.Close()
+
+	repos := make([]GenesisRepository, 0, len(uris))
+	for _, uri := range uris {
+		repo, err := k.exportRepository(store, uri)
+		if err != nil {
+			return nil, fmt.Errorf("Exporting repository '%s' failed: %s", uri, err)
+		}
+		repos = append(repos, *repo)
+	}
+
+	return repos, nil
+}
+
+// ExportRepository exports the state of a repository, or nil if it doesn't exist
+func (k Keeper) ExportRepository(ctx sdk.Context, owner string, name string) (
+	*GenesisRepository, error) {
+	uri := fmt.Sprintf("%s/%s", owner, name)
+	store := ctx.KVStore(k.gitStoreKey)
+	if !store.Has(headKey(uri)) {
+		return nil, nil
+	}
+
+	return k.exportRepository(store, uri)
+}
+
+// ImportRepositories imports the state of repositories, which must have been validated
+func (k Keeper) ImportRepositories(ctx sdk.Context, repos []GenesisRepository) error {
+	log.Debug().Msgf("Keeper importing %d repositories", len(repos))
+	store := ctx.KVStore(k.gitStoreKey)
+	for _, repo := range repos {
+		if err := k.importRepository(store, repo); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// SetRequireExplicitCreation sets whether repositories must be created before being pushed to
+func (k Keeper) SetRequireExplicitCreation(ctx sdk.Context, require bool) {
+	store := ctx.KVStore(k.gitStoreKey)
+	if require {
+		store.Set(keyRequireExplicitCreation, []byte{1})
+	} else {
+		store.Delete(keyRequireExplicitCreation)
+	}
+}
+
+// RequiresExplicitCreation says whether repositories must be created before being pushed to
+func (k Keeper) RequiresExplicitCreation(ctx sdk.Context) bool {
+	return ctx.KVStore(k.gitStoreKey).Has(keyRequireExplicitCreation)
+}
+
+// SetAdmins sets the accounts that administer all repositories
+func (k Keeper) SetAdmins(ctx sdk.Context, admins []sdk.AccAddress) {
+	store := ctx.KVStore(k.gitStoreKey)
+	if len(admins) == 0 {
+		store.Delete(keyAdmins)
+		return
+	}
+
+	store.Set(keyAdmins, k.cdc.MustMarshalBinaryBare(admins))
+}
+
+// GetAdmins gets the accounts that administer all repositories
+func (k Keeper) GetAdmins(ctx sdk.Context) []sdk.AccAddress {
+	var admins []sdk.AccAddress
+	if bz := ctx.KVStore(k.gitStoreKey).Get(keyAdmins); bz != nil {
+		k.cdc.MustUnmarshalBinaryBare(bz, &admins)
+	}
+
+	return admins
+}
+
+// SetParams sets the module parameters in the params subspace
+func (k Keeper) SetParams(ctx sdk.Context, params Params) {
+	k.paramSpace.SetParamSet(ctx, &params)
+}
+
+// GetParams gets the module parameters from the params subspace
+func (k Keeper) GetParams(ctx sdk.Context) Params {
+	var params Params
+	k.paramSpace.GetParamSet(ctx, &params)
+	return params
+}
+
+// isAdmin says whether an account administers a repository, which its owner and the accounts
+// administering all repositories do
+func (k Keeper) isAdmin(ctx sdk.Context, repo *Repository, addr sdk.AccAddress) bool {
+	if !repo.Owner.Empty() && repo.Owner.Equals(addr) {
+		return true
+	}
+
+	return k.isGlobalAdmin(ctx, addr)
+}
+
+// isGlobalAdmin says whether an account administers all repositories
+func (k Keeper) isGlobalAdmin(ctx sdk.Context, addr sdk.AccAddress) bool {
+	for _, admin := range k.GetAdmins(ctx) {
+		if admin.Equals(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DefaultBranchForPush determines the branch for HEAD to point to in a repository getting
+// created by a push. Unless specified, it's master if being pushed, otherwise the first branch
+// being pushed, so that clones check out a branch that exists.
+func DefaultBranchForPush(msg MsgUpdateReferences) plumbing.ReferenceName {
+	if msg.DefaultBranch != "" {
+		return msg.DefaultBranch
+	}
+
+	var first plumbing.ReferenceName
+	for _, cmd := range msg.Commands {
+		if !cmd.Name.IsBranch() || cmd.Action() != CreateAction {
+			continue
+		}
+		if cmd.Name == plumbing.Master {
+			return plumbing.Master
+		}
+		if first == "" {
+			first = cmd.Name
+		}
+	}
+	if first != "" {
+		return first
+	}
+
+	return plumbing.Master
+}
+
 func referenceExists(store sdk.KVStore, refPath string) bool {
 	log.Debug().Msgf("Checking if reference exists: '%s'", refPath)
 	if !store.Has([]byte(refPath)) {
@@ -188,12 +822,12 @@ func referenceExists(store sdk.KVStore, refPath string) bool {
 	return true
 }
 
-func writeReference(store sdk.KVStore, refPath string, cmd *UpdateReferenceCommand) {
-	ref := plumbing.NewHashReference(cmd.Name, cmd.New)
+// writeReference writes a reference, either symbolic or pointing to a hash
+func writeReference(store sdk.KVStore, refPath string, ref *plumbing.Reference) {
 	var content string
 	switch ref.Type() {
 	case plumbing.SymbolicReference:
-		content = fmt.Sprintf("ref: %s\n", ref.Target())
+		content = fmt.Sprintf("ref: %s", ref.Target())
 	case plumbing.HashReference:
 		content = ref.Hash().String()
 	}
@@ -201,12 +835,15 @@ func writeReference(store sdk.KVStore, refPath string, cmd *UpdateReferenceComma
 	store.Set([]byte(refPath), []byte(content))
 }
 
-// updateReferences updates references in a repository
-func updateReferences(store sdk.KVStore, msg MsgUpdateReferences) error {
-	errUpdateReference := errors.New("Failed to update reference")
+// updateReferences updates references in a repository, given what they currently point to,
+// returning the change in the number of references, or the error of the first command that
+// failed
+func updateReferences(store sdk.KVStore, msg MsgUpdateReferences, current []plumbing.Hash) (
+	int64, *commandError) {
+	var delta int64
 
 	log.Debug().Msgf("Updating references")
-	for _, cmd := range msg.Commands {
+	for i, cmd := range msg.Commands {
 		if !strings.HasPrefix(cmd.Name.String(), "refs/") {
 			panic(fmt.Sprintf("Reference doesn't start with refs/: '%s'", cmd.Name))
 		}
@@ -216,54 +853,273 @@ func updateReferences(store sdk.KVStore, msg MsgUpdateReferences) error {
 		case CreateAction:
 			if exists {
 				log.Debug().Msgf("Can't create reference '%s' as it already exists", refPath)
-				return errUpdateReference
+				return 0, &commandError{index: i, name: cmd.Name, code: CodeRefExists,
+					msg: "it already exists"}
 			}
 
 			log.Debug().Msgf("Creating reference '%s' pointing to hash '%s'", refPath,
 				cmd.New)
-			writeReference(store, refPath, cmd)
+			writeReference(store, refPath, plumbing.NewHashReference(cmd.Name, cmd.New))
+			delta++
 		case packp.Delete:
 			if !exists {
 				log.Debug().Msgf("Can't delete reference '%s' as it doesn't exist", refPath)
-				return errUpdateReference
+				return 0, &commandError{index: i, name: cmd.Name, code: CodeRefMissing,
+					msg: "it doesn't exist"}
+			}
+			if current[i] != cmd.Old {
+				log.Debug().Msgf("Can't delete reference '%s' as it points to %s, not %s",
+					refPath, current[i], cmd.Old)
+				return 0, &commandError{index: i, name: cmd.Name, code: CodeStaleOld,
+					msg: fmt.Sprintf("it points to %s, not %s", current[i], cmd.Old)}
 			}
 
 			log.Debug().Msgf("Deleting reference '%s'", refPath)
 			store.Delete([]byte(refPath))
+			delta--
 		case packp.Update:
 			if !exists {
 				log.Debug().Msgf("Can't update reference '%s' as it doesn't exist", refPath)
-				return errUpdateReference
+				return 0, &commandError{index: i, name: cmd.Name, code: CodeRefMissing,
+					msg: "it doesn't exist"}
+			}
+			if current[i] != cmd.Old {
+				log.Debug().Msgf("Can't update reference '%s' as it points to %s, not %s",
+					refPath, current[i], cmd.Old)
+				return 0, &commandError{index: i, name: cmd.Name, code: CodeStaleOld,
+					msg: fmt.Sprintf("it points to %s, not %s", current[i], cmd.Old)}
 			}
 
 			log.Debug().Msgf("Updating reference '%s' to point to hash '%s'", refPath,
 				cmd.New)
-			writeReference(store, refPath, cmd)
+			writeReference(store, refPath, plumbing.NewHashReference(cmd.Name, cmd.New))
+		}
+	}
+
+	return delta, nil
+}
+
+// writePeeledTags stores what annotated tags being pushed ultimately point to, so they can be
+// advertised without reading the tag objects
+func writePeeledTags(store sdk.KVStore, msg MsgUpdateReferences) error {
+	var objReader *objectReader
+	for i, cmd := range msg.Commands {
+		if !cmd.Name.IsTag() {
+			continue
+		}
+
+		key := PeeledKey(msg.URI, cmd.Name)
+		store.Delete(key)
+		if cmd.New.IsZero() {
+			continue
+		}
+
+		if objReader == nil {
+			var err error
+			if objReader, err = newObjectReader(store, msg.URI); err != nil {
+				return err
+			}
+		}
+		peeled, ok, err := objReader.peel(cmd.New)
+		if err == plumbing.ErrObjectNotFound {
+			return &commandError{index: i, name: cmd.Name, code: CodeMissingObjects,
+				msg: fmt.Sprintf("it points to missing object %s", cmd.New)}
+		}
+		if err != nil {
+			return err
+		}
+		if ok {
+			log.Debug().Msgf("Storing peeled value of tag '%s': %s", cmd.Name, peeled)
+			store.Set(key, []byte(peeled.String()))
+		}
+	}
+
+	return nil
+}
+
+// SetSymbolicReference points a symbolic reference, e.g. HEAD, to another reference, which
+// needn't exist yet
+func (k Keeper) SetSymbolicReference(ctx sdk.Context, msg MsgSetSymbolicReference) sdk.Error {
+	if !reRepoURI.MatchString(msg.URI) {
+		log.Debug().Msgf("Invalid repository URI: '%s'", msg.URI)
+		return ErrInvalidURI(k.codespace, msg.URI)
+	}
+
+	log.Debug().Msgf("Keeper pointing reference '%s' of repo '%s' to '%s'", msg.Name, msg.URI,
+		msg.Target)
+	store := ctx.KVStore(k.gitStoreKey)
+	repo, err := k.getRepository(store, msg.URI)
+	if err != nil {
+		return sdk.ErrInternal(err.Error())
+	}
+	if repo == nil {
+		return ErrRepoMissing(k.codespace, fmt.Sprintf("Repository doesn't exist: '%s'", msg.URI))
+	}
+	// HEAD decides what clones check out, so only admins may repoint symbolic references
+	if !k.isAdmin(ctx, repo, msg.Author) {
+		return ErrUnauthorized(k.codespace, fmt.Sprintf(
+			"%s isn't an admin of repository '%s'", msg.Author, msg.URI))
+	}
+	params := k.GetParams(ctx)
+	if msg.Name != plumbing.HEAD && !params.isRefAllowed(msg.Name) {
+		return ErrRefNotAllowed(k.codespace, fmt.Sprintf(
+			"Reference '%s' isn't allowed, only references starting with %s are", msg.Name,
+			strings.Join(params.AllowedRefPrefixes, ", ")))
+	}
+
+	refPath := fmt.Sprintf("%s/%s", msg.URI, msg.Name)
+	if msg.Name != plumbing.HEAD && !referenceExists(store, refPath) {
+		stats, err := getRepoStats(store, k.cdc, msg.URI)
+		if err != nil {
+			return sdk.ErrInternal(err.Error())
+		}
+		quotas, _ := effectiveQuotas(store, k.cdc, params, msg.URI)
+		if err := checkQuota(k.codespace, "Reference count", stats.RefCount+1,
+			quotas.MaxRefs); err != nil {
+			return err
+		}
+		stats.RefCount++
+		if err := setRepoStats(store, k.cdc, msg.URI, stats); err != nil {
+			return sdk.ErrInternal(err.Error())
 		}
 	}
+	consumeGas(ctx, params.GasPerRef, 1, "references")
+	writeReference(store, refPath, plumbing.NewSymbolicReference(msg.Name, msg.Target))
+	if msg.Name.IsTag() {
+		store.Delete(PeeledKey(msg.URI, msg.Name))
+	}
 
 	return nil
 }
 
-// RemoveRepository deletes a repository
+// RestoreReference resets a reference to what it pointed to after a change recorded in its
+// reflog, provided that the object is still stored. Only admins of the repository may do so.
+// Tags for the change are returned.
+func (k Keeper) RestoreReference(ctx sdk.Context, msg MsgRestoreReference) (sdk.Tags,
+	sdk.Error) {
+	if !reRepoURI.MatchString(msg.URI) {
+		log.Debug().Msgf("Invalid repository URI: '%s'", msg.URI)
+		return nil, ErrInvalidURI(k.codespace, msg.URI)
+	}
+
+	log.Debug().Msgf("Keeper restoring reference '%s' of repo '%s' to reflog entry %d",
+		msg.Name, msg.URI, msg.Index)
+	store := ctx.KVStore(k.gitStoreKey)
+	repo, err := k.getRepository(store, msg.URI)
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+	if repo == nil {
+		return nil, ErrRepoMissing(k.codespace, fmt.Sprintf("Repository doesn't exist: '%s'",
+			msg.URI))
+	}
+	if !k.isAdmin(ctx, repo, msg.Author) {
+		return nil, ErrUnauthorized(k.codespace, fmt.Sprintf(
+			"%s isn't an admin of repository '%s'", msg.Author, msg.URI))
+	}
+
+	entry, err := getReflogEntry(store, k.cdc, msg.URI, msg.Name, msg.Index)
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+	if entry == nil {
+		return nil, ErrInvalidReflog(k.codespace, fmt.Sprintf(
+			"Reference '%s' has no reflog entry %d", msg.Name, msg.Index))
+	}
+	if entry.New.IsZero() {
+		return nil, ErrInvalidReflog(k.codespace, fmt.Sprintf(
+			"Reflog entry %d records the deletion of '%s'", msg.Index, msg.Name))
+	}
+
+	objReader, err := newObjectReader(store, msg.URI)
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+	if err := checkObjectStored(objReader, entry.New); err != nil {
+		return nil, ErrMissingObjects(k.codespace, fmt.Sprintf("Can't restore '%s' to %s: %s",
+			msg.Name, entry.New, err))
+	}
+
+	refPath := fmt.Sprintf("%s/%s", msg.URI, msg.Name)
+	var old plumbing.Hash
+	if value := store.Get([]byte(refPath)); value != nil {
+		old = resolveRef(store, msg.URI, msg.Name, string(value))
+	} else {
+		stats, err := getRepoStats(store, k.cdc, msg.URI)
+		if err != nil {
+			return nil, sdk.ErrInternal(err.Error())
+		}
+		quotas, _ := effectiveQuotas(store, k.cdc, k.GetParams(ctx), msg.URI)
+		if err := checkQuota(k.codespace, "Reference count", stats.RefCount+1,
+			quotas.MaxRefs); err != nil {
+			return nil, err
+		}
+		stats.RefCount++
+		if err := setRepoStats(store, k.cdc, msg.URI, stats); err != nil {
+			return nil, sdk.ErrInternal(err.Error())
+		}
+	}
+	consumeGas(ctx, k.GetParams(ctx).GasPerRef, 1, "references")
+	writeReference(store, refPath, plumbing.NewHashReference(msg.Name, entry.New))
+	if msg.Name.IsTag() {
+		key := PeeledKey(msg.URI, msg.Name)
+		store.Delete(key)
+		peeled, ok, err := objReader.peel(entry.New)
+		if err != nil {
+			return nil, sdk.ErrInternal(err.Error())
+		}
+		if ok {
+			store.Set(key, []byte(peeled.String()))
+		}
+	}
+
+	if err := appendReflog(ctx, store, k.cdc, msg.URI, msg.Name, old, entry.New,
+		msg.Author); err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+
+	return repoTags(msg.URI, msg.Author).AppendTags(refTags(msg.Name.String(), old.String(),
+		entry.New.String())), nil
+}
+
+// RemoveRepository deletes a repository, refunding the storage deposits. Only admins of the
+// repository may do so.
 func (k Keeper) RemoveRepository(ctx sdk.Context, msg MsgRemoveRepository) sdk.Error {
 	if !reRepoURI.MatchString(msg.URI) {
 		log.Debug().Msgf("Invalid repository URI: '%s'", msg.URI)
-		return sdk.ErrUnknownRequest(fmt.Sprintf("Invalid repository URI: '%s'", msg.URI))
+		return ErrInvalidURI(k.codespace, msg.URI)
 	}
 
 	log.Debug().Msgf("Keeper removing repository '%s'", msg.URI)
-	// TODO: Verify that user is authorized to write to repo
 	store := ctx.KVStore(k.gitStoreKey)
-	iter := store.Iterator(nil, nil)
-	defer iter.Close()
+	repo, err := k.getRepository(store, msg.URI)
+	if err != nil {
+		return sdk.ErrInternal(err.Error())
+	}
+	if repo == nil {
+		return ErrRepoMissing(k.codespace, fmt.Sprintf("Repository doesn't exist: '%s'", msg.URI))
+	}
+	if !k.isAdmin(ctx, repo, msg.Author) {
+		return ErrUnauthorized(k.codespace, fmt.Sprintf(
+			"%s isn't an admin of repository '%s'", msg.Author, msg.URI))
+	}
+
+	unindexRepoSearch(store, *repo)
+	if err := refundDeposits(ctx, store, k.cdc, k.accountKeeper, msg.URI); err != nil {
+		return err
+	}
+	// Collect the keys first, since deleting while iterating would invalidate the iterator
+	var keys [][]byte
+	iter := sdk.KVStorePrefixIterator(store, []byte(fmt.Sprintf("%s/", msg.URI)))
 	for ; iter.Valid(); iter.Next() {
-		key := string(iter.Key())
-		if strings.HasPrefix(key, fmt.Sprintf("%s/", msg.URI)) {
-			log.Debug().Msgf("Keeper removing removing entry '%s' from store", key)
-			store.Delete(iter.Key())
-		}
+		keys = append(keys, iter.Key())
+	}
+	iter.Close()
+	for _, key := range keys {
+		log.Debug().Msgf("Keeper removing entry '%s' from store", key)
+		store.Delete(key)
 	}
+	store.Delete(repoIndexKey(msg.URI))
 
 	return nil
 }
@@ -2,6 +2,8 @@ package gitService
 
 import (
 	"encoding/json"
+	"fmt"
+	"strings"
 
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
 
@@ -64,11 +66,17 @@ type MsgUpdateReferences struct {
 	Commands []*UpdateReferenceCommand
 	Shallow  *plumbing.Hash
 	Packfile []byte
+	// PushOptions are push options given to Git (via -o/--push-option)
+	PushOptions []string
+	// DefaultBranch is the branch for HEAD to point to, in case the repository gets created.
+	// If empty, a branch being pushed is picked, preferably master.
+	DefaultBranch plumbing.ReferenceName
 }
 
 // NewMsgUpdateReferences is the constructor function for MsgUpdateReferences
 func NewMsgUpdateReferences(uri string, req *packp.ReferenceUpdateRequest,
-	packfile []byte, author sdk.AccAddress) (*MsgUpdateReferences, sdk.Error) {
+	packfile []byte, pushOptions []string, defaultBranch plumbing.ReferenceName,
+	author sdk.AccAddress) (*MsgUpdateReferences, sdk.Error) {
 	cmds := make([]*UpdateReferenceCommand, 0, len(req.Commands))
 	for _, cmd := range req.Commands {
 		cmds = append(cmds, &UpdateReferenceCommand{
@@ -77,12 +85,18 @@ func NewMsgUpdateReferences(uri string, req *packp.ReferenceUpdateRequest,
 			New:  cmd.New,
 		})
 	}
+	if len(pushOptions) == 0 {
+		// Amino decodes an empty slice as nil, which would alter the sign bytes
+		pushOptions = nil
+	}
 	msg := &MsgUpdateReferences{
-		URI:      uri,
-		Commands: cmds,
-		Packfile: packfile,
-		Shallow:  req.Shallow,
-		Author:   author,
+		URI:           uri,
+		Commands:      cmds,
+		Packfile:      packfile,
+		Shallow:       req.Shallow,
+		PushOptions:   pushOptions,
+		DefaultBranch: defaultBranch,
+		Author:        author,
 	}
 
 	return msg, msg.ValidateBasic()
@@ -108,6 +122,17 @@ func (msg MsgUpdateReferences) ValidateBasic() sdk.Error {
 		log.Debug().Msgf("MsgUpdateReferences commands empty")
 		return sdk.ErrUnknownRequest("Commands cannot be empty")
 	}
+	for _, opt := range msg.PushOptions {
+		if strings.ContainsAny(opt, "\x00\n") {
+			log.Debug().Msgf("MsgUpdateReferences push option malformed: '%s'", opt)
+			return sdk.ErrUnknownRequest(fmt.Sprintf("Malformed push option: '%s'", opt))
+		}
+	}
+	if msg.DefaultBranch != "" && !msg.DefaultBranch.IsBranch() {
+		log.Debug().Msgf("MsgUpdateReferences default branch invalid: '%s'", msg.DefaultBranch)
+		return sdk.ErrUnknownRequest(fmt.Sprintf("Default branch isn't a branch: '%s'",
+			msg.DefaultBranch))
+	}
 
 	return nil
 }
@@ -175,3 +200,305 @@ func (msg MsgRemoveRepository) GetSignBytes() []byte {
 func (msg MsgRemoveRepository) GetSigners() []sdk.AccAddress {
 	return []sdk.AccAddress{msg.Author}
 }
+
+// MsgSetSymbolicReference defines the SetSymbolicReference message, which points a symbolic
+// reference, e.g. HEAD, to another reference
+type MsgSetSymbolicReference struct {
+	URI    string
+	Author sdk.AccAddress
+	Name   plumbing.ReferenceName
+	Target plumbing.ReferenceName
+}
+
+// NewMsgSetSymbolicReference is the constructor function for MsgSetSymbolicReference
+func NewMsgSetSymbolicReference(uri string, name plumbing.ReferenceName,
+	target plumbing.ReferenceName, author sdk.AccAddress) (*MsgSetSymbolicReference, sdk.Error) {
+	msg := &MsgSetSymbolicReference{
+		URI:    uri,
+		Author: author,
+		Name:   name,
+		Target: target,
+	}
+
+	return msg, msg.ValidateBasic()
+}
+
+// Route implements Msg.
+func (msg MsgSetSymbolicReference) Route() string { return "gitService" }
+
+// Type implements Msg.
+func (msg MsgSetSymbolicReference) Type() string { return "setSymbolicReference" }
+
+// ValidateBasic Implements Msg.
+func (msg MsgSetSymbolicReference) ValidateBasic() sdk.Error {
+	if msg.Author.Empty() {
+		log.Debug().Msgf("MsgSetSymbolicReference author empty")
+		return sdk.ErrInvalidAddress(msg.Author.String())
+	}
+	if len(msg.URI) == 0 {
+		log.Debug().Msgf("MsgSetSymbolicReference URI empty")
+		return sdk.ErrUnknownRequest("URI cannot be empty")
+	}
+	if msg.Name != plumbing.HEAD && !isValidRefName(msg.Name) {
+		log.Debug().Msgf("MsgSetSymbolicReference name invalid: '%s'", msg.Name)
+		return sdk.ErrUnknownRequest(fmt.Sprintf("Invalid reference name: '%s'", msg.Name))
+	}
+	if !isValidRefName(msg.Target) {
+		log.Debug().Msgf("MsgSetSymbolicReference target invalid: '%s'", msg.Target)
+		return sdk.ErrUnknownRequest(fmt.Sprintf("Invalid reference target: '%s'", msg.Target))
+	}
+	if msg.Name == msg.Target {
+		return sdk.ErrUnknownRequest("A reference can't point to itself")
+	}
+
+	return nil
+}
+
+// GetSignBytes Implements Msg.
+func (msg MsgSetSymbolicReference) GetSignBytes() []byte {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return sdk.MustSortJSON(b)
+}
+
+// GetSigners Implements Msg.
+func (msg MsgSetSymbolicReference) GetSigners() []sdk.AccAddress {
+	return []sdk.AccAddress{msg.Author}
+}
+
+// MsgCreateRepository defines the CreateRepository message, which creates a repository with
+// metadata, before anything is pushed to it
+type MsgCreateRepository struct {
+	URI    string
+	Author sdk.AccAddress
+	// Description describes the repository
+	Description string
+	// DefaultBranch is the branch for HEAD to point to, master if empty
+	DefaultBranch plumbing.ReferenceName
+	// Visibility says who the repository is meant for, public if empty
+	Visibility Visibility
+	// License is an SPDX license identifier, e.g. MIT
+	License string
+	// Topics classify the repository, for searching
+	Topics []string
+}
+
+// NewMsgCreateRepository is the constructor function for MsgCreateRepository
+func NewMsgCreateRepository(uri string, description string, defaultBranch plumbing.ReferenceName,
+	visibility Visibility, license string, topics []string, author sdk.AccAddress) (
+	*MsgCreateRepository, sdk.Error) {
+	// Amino decodes empty slices as nil, which would make the signature fail to verify
+	if len(topics) == 0 {
+		topics = nil
+	}
+	msg := &MsgCreateRepository{
+		URI:           uri,
+		Author:        author,
+		Description:   description,
+		DefaultBranch: defaultBranch,
+		Visibility:    visibility,
+		License:       license,
+		Topics:        topics,
+	}
+
+	return msg, msg.ValidateBasic()
+}
+
+// Route implements Msg.
+func (msg MsgCreateRepository) Route() string { return "gitService" }
+
+// Type implements Msg.
+func (msg MsgCreateRepository) Type() string { return "createRepository" }
+
+// ValidateBasic Implements Msg.
+func (msg MsgCreateRepository) ValidateBasic() sdk.Error {
+	if msg.Author.Empty() {
+		log.Debug().Msgf("MsgCreateRepository author empty")
+		return sdk.ErrInvalidAddress(msg.Author.String())
+	}
+	if !reRepoURI.MatchString(msg.URI) {
+		log.Debug().Msgf("MsgCreateRepository URI invalid: '%s'", msg.URI)
+		return ErrInvalidURI(DefaultCodespace, msg.URI)
+	}
+	if len(msg.Description) > maxDescriptionLength {
+		return ErrInvalidRepo(DefaultCodespace, fmt.Sprintf("Description is longer than %d bytes",
+			maxDescriptionLength))
+	}
+	if msg.DefaultBranch != "" && !(msg.DefaultBranch.IsBranch() &&
+		isValidRefName(msg.DefaultBranch)) {
+		log.Debug().Msgf("MsgCreateRepository default branch invalid: '%s'", msg.DefaultBranch)
+		return ErrInvalidRepo(DefaultCodespace, fmt.Sprintf("Invalid default branch: '%s'",
+			msg.DefaultBranch))
+	}
+	if msg.Visibility != "" && !msg.Visibility.Valid() {
+		return ErrInvalidRepo(DefaultCodespace, fmt.Sprintf("Invalid visibility: '%s'",
+			msg.Visibility))
+	}
+	if len(msg.License) > maxLicenseLength || strings.ContainsAny(msg.License, " \n") {
+		return ErrInvalidRepo(DefaultCodespace, fmt.Sprintf("Invalid license identifier: '%s'",
+			msg.License))
+	}
+	if len(msg.Topics) > maxTopics {
+		return ErrInvalidRepo(DefaultCodespace, fmt.Sprintf("More than %d topics", maxTopics))
+	}
+	for _, topic := range msg.Topics {
+		if !reTopic.MatchString(topic) {
+			return ErrInvalidRepo(DefaultCodespace, fmt.Sprintf("Invalid topic: '%s'", topic))
+		}
+	}
+
+	return nil
+}
+
+// GetSignBytes Implements Msg.
+func (msg MsgCreateRepository) GetSignBytes() []byte {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return sdk.MustSortJSON(b)
+}
+
+// GetSigners Implements Msg.
+func (msg MsgCreateRepository) GetSigners() []sdk.AccAddress {
+	return []sdk.AccAddress{msg.Author}
+}
+
+// MsgRestoreReference defines the RestoreReference message, which resets a reference to what
+// it pointed to after a change recorded in its reflog
+type MsgRestoreReference struct {
+	URI    string
+	Author sdk.AccAddress
+	Name   plumbing.ReferenceName
+	// Index is the index of the reflog entry to restore
+	Index uint64
+}
+
+// NewMsgRestoreReference is the constructor function for MsgRestoreReference
+func NewMsgRestoreReference(uri string, name plumbing.ReferenceName, index uint64,
+	author sdk.AccAddress) (*MsgRestoreReference, sdk.Error) {
+	msg := &MsgRestoreReference{
+		URI:    uri,
+		Author: author,
+		Name:   name,
+		Index:  index,
+	}
+
+	return msg, msg.ValidateBasic()
+}
+
+// Route implements Msg.
+func (msg MsgRestoreReference) Route() string { return "gitService" }
+
+// Type implements Msg.
+func (msg MsgRestoreReference) Type() string { return "restoreReference" }
+
+// ValidateBasic Implements Msg.
+func (msg MsgRestoreReference) ValidateBasic() sdk.Error {
+	if msg.Author.Empty() {
+		log.Debug().Msgf("MsgRestoreReference author empty")
+		return sdk.ErrInvalidAddress(msg.Author.String())
+	}
+	if len(msg.URI) == 0 {
+		log.Debug().Msgf("MsgRestoreReference URI empty")
+		return sdk.ErrUnknownRequest("URI cannot be empty")
+	}
+	if !isValidRefName(msg.Name) {
+		log.Debug().Msgf("MsgRestoreReference name invalid: '%s'", msg.Name)
+		return sdk.ErrUnknownRequest(fmt.Sprintf("Invalid reference name: '%s'", msg.Name))
+	}
+	if msg.Index == 0 {
+		return sdk.ErrUnknownRequest("Reflog indexes start at 1")
+	}
+
+	return nil
+}
+
+// GetSignBytes Implements Msg.
+func (msg MsgRestoreReference) GetSignBytes() []byte {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return sdk.MustSortJSON(b)
+}
+
+// GetSigners Implements Msg.
+func (msg MsgRestoreReference) GetSigners() []sdk.AccAddress {
+	return []sdk.AccAddress{msg.Author}
+}
+
+// isValidRefName says whether a reference name is below refs/ and free of characters that
+// Git disallows
+func isValidRefName(name plumbing.ReferenceName) bool {
+	s := name.String()
+	if !strings.HasPrefix(s, "refs/") || strings.HasSuffix(s, "/") ||
+		strings.Contains(s, "..") || strings.Contains(s, "//") {
+		return false
+	}
+
+	return !strings.ContainsAny(s, " ~^:?*[\\\x00\n")
+}
+
+// MsgSetRepoQuotas defines the SetRepoQuotas message, which sets the quotas of a repository,
+// overriding those of the module parameters
+type MsgSetRepoQuotas struct {
+	URI    string
+	Author sdk.AccAddress
+	// Quotas are the quotas of the repository, nil to apply those of the module parameters
+	// again
+	Quotas *Quotas
+}
+
+// NewMsgSetRepoQuotas is the constructor function for MsgSetRepoQuotas
+func NewMsgSetRepoQuotas(uri string, quotas *Quotas, author sdk.AccAddress) (*MsgSetRepoQuotas,
+	sdk.Error) {
+	msg := &MsgSetRepoQuotas{
+		URI:    uri,
+		Author: author,
+		Quotas: quotas,
+	}
+
+	return msg, msg.ValidateBasic()
+}
+
+// Route implements Msg.
+func (msg MsgSetRepoQuotas) Route() string { return "gitService" }
+
+// Type implements Msg.
+func (msg MsgSetRepoQuotas) Type() string { return "setRepoQuotas" }
+
+// ValidateBasic Implements Msg.
+func (msg MsgSetRepoQuotas) ValidateBasic() sdk.Error {
+	if msg.Author.Empty() {
+		log.Debug().Msgf("MsgSetRepoQuotas author empty")
+		return sdk.ErrInvalidAddress(msg.Author.String())
+	}
+	if !reRepoURI.MatchString(msg.URI) {
+		log.Debug().Msgf("MsgSetRepoQuotas URI invalid: '%s'", msg.URI)
+		return ErrInvalidURI(DefaultCodespace, msg.URI)
+	}
+	if msg.Quotas != nil {
+		if err := msg.Quotas.validate(); err != nil {
+			return sdk.ErrUnknownRequest(err.Error())
+		}
+	}
+
+	return nil
+}
+
+// GetSignBytes Implements Msg.
+func (msg MsgSetRepoQuotas) GetSignBytes() []byte {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return sdk.MustSortJSON(b)
+}
+
+// GetSigners Implements Msg.
+func (msg MsgSetRepoQuotas) GetSigners() []sdk.AccAddress {
+	return []sdk.AccAddress{msg.Author}
+}
@@ -0,0 +1,214 @@
+package gitService
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/idxfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// IndexKey is the store key of the index of a packfile stored for a repository
+func IndexKey(repoURI string, h plumbing.Hash) []byte {
+	return []byte(fmt.Sprintf("%s/objects/pack/pack-%s.idx", repoURI, h))
+}
+
+// readIndex reads the index of a packfile stored for a repository
+func readIndex(store sdk.KVStore, repoURI string, h plumbing.Hash) (idxfile.Index, error) {
+	path := IndexKey(repoURI, h)
+	b := store.Get(path)
+	if b == nil {
+		return nil, fmt.Errorf("Couldn't get index %s", path)
+	}
+
+	idx := idxfile.NewMemoryIndex()
+	if err := idxfile.NewDecoder(bytes.NewBuffer(b)).Decode(idx); err != nil {
+		return nil, err
+	}
+
+	return idx, nil
+}
+
+// objectPacks finds the packfiles stored for a repository whose indexes contain an object
+func objectPacks(store sdk.KVStore, repoURI string, h plumbing.Hash) ([]plumbing.Hash, error) {
+	packs, err := listPackfiles(store, repoURI)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []plumbing.Hash
+	for _, pack := range packs {
+		idx, err := readIndex(store, repoURI, pack)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := idx.Contains(h)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			found = append(found, pack)
+		}
+	}
+
+	return found, nil
+}
+
+// objectReader reads Git objects from the packfiles stored for a repository
+type objectReader struct {
+	store   sdk.KVStore
+	repoURI string
+	packs   []plumbing.Hash
+	indexes map[plumbing.Hash]idxfile.Index
+	opened  map[plumbing.Hash]*packfile.Packfile
+}
+
+func newObjectReader(store sdk.KVStore, repoURI string) (*objectReader, error) {
+	packs, err := listPackfiles(store, repoURI)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &objectReader{
+		store:   store,
+		repoURI: repoURI,
+		packs:   packs,
+		indexes: make(map[plumbing.Hash]idxfile.Index),
+		opened:  make(map[plumbing.Hash]*packfile.Packfile),
+	}
+	for _, h := range packs {
+		idx, err := readIndex(store, repoURI, h)
+		if err != nil {
+			return nil, err
+		}
+
+		r.indexes[h] = idx
+	}
+
+	return r, nil
+}
+
+// getObject gets an object by its hash
+func (r *objectReader) getObject(h plumbing.Hash) (plumbing.EncodedObject, error) {
+	for _, packHash := range r.packs {
+		found, err := r.indexes[packHash].Contains(h)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+
+		pack, ok := r.opened[packHash]
+		if !ok {
+			path := fmt.Sprintf("%s/objects/pack/pack-%s.pack", r.repoURI, packHash)
+			b := r.store.Get([]byte(path))
+			if b == nil {
+				return nil, fmt.Errorf("Couldn't get packfile %s", path)
+			}
+
+			pack = packfile.NewPackfile(r.indexes[packHash], nil, newMemFile(path, b))
+			r.opened[packHash] = pack
+		}
+
+		return pack.Get(h)
+	}
+
+	return nil, plumbing.ErrObjectNotFound
+}
+
+// peel resolves an annotated tag to the object it ultimately points to. If h isn't an
+// annotated tag, false is returned.
+func (r *objectReader) peel(h plumbing.Hash) (plumbing.Hash, bool, error) {
+	peeled := false
+	for {
+		obj, err := r.getObject(h)
+		if err != nil {
+			return plumbing.ZeroHash, false, err
+		}
+		if obj.Type() != plumbing.TagObject {
+			return h, peeled, nil
+		}
+
+		tag := &object.Tag{}
+		if err := tag.Decode(obj); err != nil {
+			return plumbing.ZeroHash, false, err
+		}
+		log.Debug().Msgf("Peeled tag %s to %s", h, tag.Target)
+		h = tag.Target
+		peeled = true
+	}
+}
+
+// memFile is a read-only billy.File over a byte slice, used for reading stored packfiles
+type memFile struct {
+	*bytes.Reader
+	name string
+}
+
+func newMemFile(name string, b []byte) *memFile {
+	return &memFile{
+		Reader: bytes.NewReader(b),
+		name:   name,
+	}
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	return 0, errors.New("memFile is read-only")
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+func (f *memFile) Lock() error {
+	return nil
+}
+
+func (f *memFile) Unlock() error {
+	return nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	return errors.New("memFile is read-only")
+}
+
+// checkObjectStored checks that an object is stored, and if it's a commit, that its tree is
+// too, or if it's an annotated tag, that what it points to is
+func checkObjectStored(r *objectReader, h plumbing.Hash) error {
+	obj, err := r.getObject(h)
+	if err == plumbing.ErrObjectNotFound {
+		return fmt.Errorf("object %s isn't stored", h)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch obj.Type() {
+	case plumbing.CommitObject:
+		commit := &object.Commit{}
+		if err := commit.Decode(obj); err != nil {
+			return err
+		}
+		if _, err := r.getObject(commit.TreeHash); err != nil {
+			return fmt.Errorf("tree %s of commit %s isn't stored", commit.TreeHash, h)
+		}
+	case plumbing.TagObject:
+		tag := &object.Tag{}
+		if err := tag.Decode(obj); err != nil {
+			return err
+		}
+		return checkObjectStored(r, tag.Target)
+	}
+
+	return nil
+}
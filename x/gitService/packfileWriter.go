@@ -12,7 +12,6 @@ import (
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/idxfile"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
-	"gopkg.in/src-d/go-git.v4/utils/ioutil"
 
 	sdk "github.com/cosmos/cosmos-sdk/types"
 )
@@ -28,7 +27,7 @@ func (pw *PackWriter) requireIndex() error {
 		pw.packMap = make(map[plumbing.Hash]struct{})
 		pw.packList = nil
 
-		packfileHashes, err := pw.objectPacks()
+		packfileHashes, err := listPackfiles(pw.store, pw.repoURI)
 		if err != nil {
 			return err
 		}
@@ -50,33 +49,23 @@ func (pw *PackWriter) requireIndex() error {
 
 // loadIdx loads an index corresponding to a packfile
 func (pw *PackWriter) loadIdx(h plumbing.Hash) (err error) {
-	path := fmt.Sprintf("%s/objects/pack/pack-%s.idx", pw.repoURI, h)
-	b := pw.store.Get([]byte(path))
-	if b == nil {
-		return fmt.Errorf("Couldn't get index %s", path)
-	}
-
-	r := bytes.NewBuffer(b)
-
-	idx := idxfile.NewMemoryIndex()
-	d := idxfile.NewDecoder(r)
-	if err = d.Decode(idx); err != nil {
+	idx, err := readIndex(pw.store, pw.repoURI, h)
+	if err != nil {
 		return err
 	}
 
 	pw.index[h] = idx
-	return err
+	return nil
 }
 
-// objectPacks gets hashes of packfiles stored for the repository
-func (pw *PackWriter) objectPacks() ([]plumbing.Hash, error) {
-	iter := pw.store.Iterator(nil, nil)
+// listPackfiles gets hashes of packfiles stored for a repository
+func listPackfiles(store sdk.KVStore, repoURI string) ([]plumbing.Hash, error) {
+	iter := sdk.KVStorePrefixIterator(store, []byte(fmt.Sprintf("%s/objects/pack/", repoURI)))
 	defer iter.Close()
 	var packs []plumbing.Hash
 	for ; iter.Valid(); iter.Next() {
 		key := string(iter.Key())
-		if strings.HasPrefix(key, fmt.Sprintf("%s/objects/pack/", pw.repoURI)) &&
-			strings.HasSuffix(key, ".pack") {
+		if strings.HasSuffix(key, ".pack") {
 			components := strings.Split(key, "/")
 			n := components[len(components)-1]
 			// pack-(hash).pack
@@ -93,7 +82,7 @@ func (pw *PackWriter) objectPacks() ([]plumbing.Hash, error) {
 	return packs, nil
 }
 
-func getPackfileWriter(store sdk.KVStore, repoURI string) (io.WriteCloser, error) {
+func getPackfileWriter(store sdk.KVStore, repoURI string) (*PackWriter, error) {
 	fw, err := stdIOUtil.TempFile("", "packfile")
 	if err != nil {
 		return nil, err
@@ -140,6 +129,8 @@ type PackWriter struct {
 	fr        *os.File
 	synced    *syncedReader
 	checksum  plumbing.Hash
+	objects   int64
+	sizes     *objectSizeObserver
 	parser    *packfile.Parser
 	idxWriter *idxfile.Writer
 	result    chan error
@@ -156,7 +147,8 @@ func (pw *PackWriter) buildIndex() {
 	s := packfile.NewScanner(pw.synced)
 	pw.idxWriter = new(idxfile.Writer)
 	var err error
-	pw.parser, err = packfile.NewParser(s, pw.idxWriter)
+	pw.sizes = &objectSizeObserver{}
+	pw.parser, err = packfile.NewParser(s, pw.idxWriter, pw.sizes)
 	if err != nil {
 		log.Debug().Msgf("Creating parser failed: %s", err)
 		pw.result <- err
@@ -243,6 +235,9 @@ func (pw *PackWriter) save() error {
 		log.Debug().Msgf("Packwriter - encoding index failed: %s", err)
 		return err
 	}
+	if pw.objects, err = idx.Count(); err != nil {
+		return err
+	}
 
 	packfilePath := fmt.Sprintf("%s/objects/pack/pack-%s.pack", pw.repoURI, pw.checksum)
 	log.Debug().Msgf("Saving packfile to '%s'", packfilePath)
@@ -262,21 +257,67 @@ func (pw *PackWriter) save() error {
 	return nil
 }
 
+// objectSizeObserver is a packfile parser observer that determines the size of the largest
+// object
+type objectSizeObserver struct {
+	max int64
+}
+
+func (o *objectSizeObserver) OnHeader(count uint32) error {
+	return nil
+}
+
+func (o *objectSizeObserver) OnInflatedObjectHeader(t plumbing.ObjectType, objSize int64,
+	pos int64) error {
+	return nil
+}
+
+// OnInflatedObjectContent gets called with the content of each object, also those stored as
+// deltas
+func (o *objectSizeObserver) OnInflatedObjectContent(h plumbing.Hash, pos int64, crc uint32,
+	content []byte) error {
+	if size := int64(len(content)); size > o.max {
+		o.max = size
+	}
+	return nil
+}
+
+func (o *objectSizeObserver) OnFooter(h plumbing.Hash) error {
+	return nil
+}
+
+// storedPackfile describes a packfile written to a repository
+type storedPackfile struct {
+	checksum plumbing.Hash
+	objects  int64
+	// maxObjectSize is the size of the largest object in the packfile
+	maxObjectSize int64
+}
+
 // writePackfile writes a packfile and its index to a repository
-func writePackfile(store sdk.KVStore, msg MsgUpdateReferences) (err error) {
+func writePackfile(store sdk.KVStore, msg MsgUpdateReferences) (storedPackfile, error) {
 	log.Debug().Msgf("Keeper - writing packfile and index to %s/objects/pack/", msg.URI)
 	pw, err := getPackfileWriter(store, msg.URI)
 	if err != nil {
-		return err
+		return storedPackfile{}, err
 	}
 
-	defer ioutil.CheckClose(pw, &err)
 	log.Debug().Msgf("Copying packfile to packfile writer, %d bytes\n",
 		len(msg.Packfile))
 	buf := bytes.NewBuffer(msg.Packfile)
 	log.Debug().Msgf("Length of packfile buffer: %d", buf.Len())
 	_, err = io.Copy(pw, buf)
+	if closeErr := pw.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return storedPackfile{}, err
+	}
 	log.Debug().Msgf("Finished copying to packfile writer")
 
-	return nil
+	pack := storedPackfile{checksum: pw.checksum, objects: pw.objects}
+	if pw.sizes != nil {
+		pack.maxObjectSize = pw.sizes.max
+	}
+	return pack, nil
 }
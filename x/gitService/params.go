@@ -0,0 +1,115 @@
+package gitService
+
+import (
+	"fmt"
+	"strings"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/x/params"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// DefaultParamspace is the name of the params subspace holding the gitService module parameters
+const DefaultParamspace = "gitService"
+
+// Parameter store keys, which parameter change proposals refer to
+var (
+	KeyGasPerByte         = []byte("GasPerByte")
+	KeyGasPerObject       = []byte("GasPerObject")
+	KeyGasPerRef          = []byte("GasPerRef")
+	KeyDepositPerKiB      = []byte("DepositPerKiB")
+	KeyQuotas             = []byte("Quotas")
+	KeyAllowedRefPrefixes = []byte("AllowedRefPrefixes")
+	KeyRepoCreationFee    = []byte("RepoCreationFee")
+)
+
+var _ params.ParamSet = (*Params)(nil)
+
+// Params are the gitService module parameters
+type Params struct {
+	// GasPerByte is the gas charged per byte of packfile pushed, on top of the gas for storing
+	// it
+	GasPerByte sdk.Gas `json:"gas_per_byte"`
+	// GasPerObject is the gas charged per object parsed from a packfile pushed
+	GasPerObject sdk.Gas `json:"gas_per_object"`
+	// GasPerRef is the gas charged per reference written
+	GasPerRef sdk.Gas `json:"gas_per_ref"`
+	// DepositPerKiB is the refundable deposit taken per KiB a push adds to the store, nothing
+	// by default
+	DepositPerKiB sdk.Coins `json:"deposit_per_kib"`
+	// Quotas are the quotas of repositories without quotas of their own, none by default
+	Quotas Quotas `json:"quotas"`
+	// AllowedRefPrefixes are the prefixes, e.g. refs/heads/, of the references that may be
+	// written, any below refs/ by default
+	AllowedRefPrefixes []string `json:"allowed_ref_prefixes"`
+	// RepoCreationFee is the fee charged to the owner of a repository getting created, nothing
+	// by default
+	RepoCreationFee sdk.Coins `json:"repo_creation_fee"`
+}
+
+// DefaultParams returns the default gitService module parameters
+func DefaultParams() Params {
+	return Params{
+		GasPerByte:   1,
+		GasPerObject: 100,
+		GasPerRef:    1000,
+	}
+}
+
+// KeyValuePairs implements params.ParamSet
+func (p *Params) KeyValuePairs() params.KeyValuePairs {
+	return params.KeyValuePairs{
+		{Key: KeyGasPerByte, Value: &p.GasPerByte},
+		{Key: KeyGasPerObject, Value: &p.GasPerObject},
+		{Key: KeyGasPerRef, Value: &p.GasPerRef},
+		{Key: KeyDepositPerKiB, Value: &p.DepositPerKiB},
+		{Key: KeyQuotas, Value: &p.Quotas},
+		{Key: KeyAllowedRefPrefixes, Value: &p.AllowedRefPrefixes},
+		{Key: KeyRepoCreationFee, Value: &p.RepoCreationFee},
+	}
+}
+
+// ParamTypeTable returns the type table of the gitService params subspace
+func ParamTypeTable() params.TypeTable {
+	return params.NewTypeTable().RegisterParamSet(&Params{})
+}
+
+// Validate checks that the parameters are sensible
+func (p Params) Validate() error {
+	if err := p.Quotas.validate(); err != nil {
+		return err
+	}
+	for _, prefix := range p.AllowedRefPrefixes {
+		if !strings.HasPrefix(prefix, "refs/") {
+			return fmt.Errorf("Allowed reference prefix doesn't start with refs/: '%s'", prefix)
+		}
+	}
+	if !p.DepositPerKiB.IsValid() {
+		return fmt.Errorf("Invalid storage deposit rate: %s", p.DepositPerKiB)
+	}
+	if !p.RepoCreationFee.IsValid() {
+		return fmt.Errorf("Invalid repository creation fee: %s", p.RepoCreationFee)
+	}
+
+	return nil
+}
+
+// isRefAllowed says whether a reference may be written
+func (p Params) isRefAllowed(name plumbing.ReferenceName) bool {
+	if len(p.AllowedRefPrefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range p.AllowedRefPrefixes {
+		if strings.HasPrefix(name.String(), prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// consumeGas charges gas for n units of something, e.g. bytes
+func consumeGas(ctx sdk.Context, gasPerUnit sdk.Gas, n int64, descriptor string) {
+	ctx.GasMeter().ConsumeGas(gasPerUnit*sdk.Gas(n), descriptor)
+}
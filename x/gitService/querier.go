@@ -3,10 +3,12 @@ package gitService
 import (
 	encJson "encoding/json"
 	"fmt"
+	"strings"
 
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	"github.com/rs/zerolog/log"
 	abci "github.com/tendermint/tendermint/abci/types"
+	"gopkg.in/src-d/go-git.v4/plumbing"
 )
 
 // NewQuerier is the module level router for state queries
@@ -18,6 +20,26 @@ func NewQuerier(keeper Keeper) sdk.Querier {
 			return queryListRefs(ctx, path[1:], req, keeper)
 		case "advertisedReferences":
 			return queryAdvertisedReferences(ctx, path[1:], req, keeper)
+		case "packfiles":
+			return queryPackfiles(ctx, path[1:], req, keeper)
+		case "packfile":
+			return queryPackfile(ctx, path[1:], req, keeper)
+		case "repository":
+			return queryRepository(ctx, path[1:], req, keeper)
+		case "repos":
+			return queryRepos(ctx, req, keeper)
+		case "search":
+			return querySearch(ctx, req, keeper)
+		case "reflog":
+			return queryReflog(ctx, path[1:], req, keeper)
+		case "storage":
+			return queryStorage(ctx, path[1:], keeper)
+		case "quotas":
+			return queryQuotas(ctx, path[1:], keeper)
+		case "params":
+			return queryParams(ctx, keeper)
+		case "objectPacks":
+			return queryObjectPacks(ctx, path[1:], keeper)
 		default:
 			return nil, sdk.ErrUnknownRequest(
 				fmt.Sprintf("Unknown gitService query endpoint: '%s'", root))
@@ -25,12 +47,34 @@ func NewQuerier(keeper Keeper) sdk.Querier {
 	}
 }
 
-// nolint: unparam
+// parseRefsQuery parses the optional RefsQuery passed as query data
+func parseRefsQuery(req abci.RequestQuery) (RefsQuery, sdk.Error) {
+	var query RefsQuery
+	if len(req.Data) == 0 {
+		return query, nil
+	}
+	if err := encJson.Unmarshal(req.Data, &query); err != nil {
+		return query, sdk.ErrUnknownRequest(fmt.Sprintf("Invalid references query: %s", err))
+	}
+
+	return query, nil
+}
+
 func queryListRefs(ctx sdk.Context, path []string, req abci.RequestQuery, keeper Keeper) (
 	[]byte, sdk.Error) {
 	log.Debug().Msgf("queryListRefs: %v", path)
-	refs := keeper.ListRefs(ctx, path[0], path[1])
-	bytes, err := encJson.Marshal(refs)
+	if len(path) != 2 {
+		return nil, sdk.ErrUnknownRequest("List refs query requires a repository")
+	}
+	query, sdkErr := parseRefsQuery(req)
+	if sdkErr != nil {
+		return nil, sdkErr
+	}
+	resp, err := keeper.ListRefs(ctx, path[0], path[1], query)
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+	bytes, err := encJson.Marshal(resp)
 	if err != nil {
 		return nil, sdk.ErrInternal(err.Error())
 	}
@@ -41,13 +85,236 @@ func queryListRefs(ctx sdk.Context, path []string, req abci.RequestQuery, keeper
 func queryAdvertisedReferences(ctx sdk.Context, path []string, req abci.RequestQuery, keeper Keeper) (
 	[]byte, sdk.Error) {
 	log.Debug().Msgf("Querying for advertised references")
-	advRefs, err := keeper.GetAdvertisedReferences(ctx, path[0], path[1])
+	if len(path) != 2 {
+		return nil, sdk.ErrUnknownRequest("Advertised references query requires a repository")
+	}
+	query, sdkErr := parseRefsQuery(req)
+	if sdkErr != nil {
+		return nil, sdkErr
+	}
+	resp, err := keeper.GetAdvertisedReferences(ctx, path[0], path[1], query)
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+
+	log.Debug().Msgf("Returning advertised references: %+v, next: '%s'", resp.AdvRefs,
+		resp.Next)
+	bytes, err := encJson.Marshal(resp)
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+
+	return bytes, nil
+}
+
+// nolint: unparam
+func queryPackfiles(ctx sdk.Context, path []string, req abci.RequestQuery, keeper Keeper) (
+	[]byte, sdk.Error) {
+	log.Debug().Msgf("Querying for packfiles: %v", path)
+	if len(path) != 2 {
+		return nil, sdk.ErrUnknownRequest("Packfiles query requires a repository")
+	}
+	packs, err := keeper.ListPackfiles(ctx, path[0], path[1])
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+
+	hashes := make([]string, 0, len(packs))
+	for _, h := range packs {
+		hashes = append(hashes, h.String())
+	}
+	bytes, err := encJson.Marshal(hashes)
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+
+	return bytes, nil
+}
+
+// nolint: unparam
+func queryPackfile(ctx sdk.Context, path []string, req abci.RequestQuery, keeper Keeper) (
+	[]byte, sdk.Error) {
+	log.Debug().Msgf("Querying for packfile: %v", path)
+	if len(path) != 3 {
+		return nil, sdk.ErrUnknownRequest("Packfile query requires a repository and a hash")
+	}
+	pack := keeper.GetPackfile(ctx, path[0], path[1], plumbing.NewHash(path[2]))
+	if pack == nil {
+		return nil, sdk.ErrUnknownRequest(fmt.Sprintf("Packfile not found: '%s'", path[2]))
+	}
+
+	return pack, nil
+}
+
+// nolint: unparam
+func queryRepository(ctx sdk.Context, path []string, req abci.RequestQuery, keeper Keeper) (
+	[]byte, sdk.Error) {
+	log.Debug().Msgf("Querying for repository: %v", path)
+	if len(path) != 2 {
+		return nil, sdk.ErrUnknownRequest("Repository query requires a repository")
+	}
+	repo, err := keeper.GetRepository(ctx, path[0], path[1])
 	if err != nil {
 		return nil, sdk.ErrInternal(err.Error())
 	}
+	if repo == nil {
+		return nil, sdk.ErrUnknownRequest(fmt.Sprintf("Repository not found: '%s/%s'", path[0],
+			path[1]))
+	}
+
+	bytes, err := encJson.Marshal(repo)
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+
+	return bytes, nil
+}
+
+func queryRepos(ctx sdk.Context, req abci.RequestQuery, keeper Keeper) ([]byte, sdk.Error) {
+	log.Debug().Msgf("Querying for repositories")
+	var query ReposQuery
+	if len(req.Data) > 0 {
+		if err := encJson.Unmarshal(req.Data, &query); err != nil {
+			return nil, sdk.ErrUnknownRequest(fmt.Sprintf("Invalid repositories query: %s", err))
+		}
+	}
+	resp, err := keeper.ListRepositories(ctx, query)
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+
+	bytes, err := encJson.Marshal(resp)
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+
+	return bytes, nil
+}
+
+func querySearch(ctx sdk.Context, req abci.RequestQuery, keeper Keeper) ([]byte, sdk.Error) {
+	log.Debug().Msgf("Querying for search")
+	var query SearchQuery
+	if err := encJson.Unmarshal(req.Data, &query); err != nil {
+		return nil, sdk.ErrUnknownRequest(fmt.Sprintf("Invalid search query: %s", err))
+	}
+	if len(searchWords(query.Text)) == 0 && len(query.Topics) == 0 {
+		return nil, sdk.ErrUnknownRequest("Search query has neither text nor topics")
+	}
+	results, err := keeper.Search(ctx, query)
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+
+	if results == nil {
+		results = []SearchResult{}
+	}
+	bytes, err := encJson.Marshal(results)
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+
+	return bytes, nil
+}
+
+func queryReflog(ctx sdk.Context, path []string, req abci.RequestQuery, keeper Keeper) (
+	[]byte, sdk.Error) {
+	log.Debug().Msgf("Querying for reflog: %v", path)
+	if len(path) < 3 {
+		return nil, sdk.ErrUnknownRequest("Reflog query requires a repository and a reference")
+	}
+	var query ReflogQuery
+	if len(req.Data) > 0 {
+		if err := encJson.Unmarshal(req.Data, &query); err != nil {
+			return nil, sdk.ErrUnknownRequest(fmt.Sprintf("Invalid reflog query: %s", err))
+		}
+	}
+	name := plumbing.ReferenceName(strings.Join(path[2:], "/"))
+	resp, err := keeper.GetReflog(ctx, path[0], path[1], name, query)
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+
+	bytes, err := encJson.Marshal(resp)
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+
+	return bytes, nil
+}
+
+func queryStorage(ctx sdk.Context, path []string, keeper Keeper) ([]byte, sdk.Error) {
+	log.Debug().Msgf("Querying for storage: %v", path)
+	if len(path) != 2 {
+		return nil, sdk.ErrUnknownRequest("Storage query requires a repository")
+	}
+	resp, err := keeper.GetStorage(ctx, path[0], path[1])
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+	if resp == nil {
+		return nil, sdk.ErrUnknownRequest(fmt.Sprintf("Repository not found: '%s/%s'", path[0],
+			path[1]))
+	}
+
+	bytes, err := encJson.Marshal(resp)
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+
+	return bytes, nil
+}
+
+func queryQuotas(ctx sdk.Context, path []string, keeper Keeper) ([]byte, sdk.Error) {
+	log.Debug().Msgf("Querying for quotas: %v", path)
+	if len(path) != 2 {
+		return nil, sdk.ErrUnknownRequest("Quotas query requires a repository")
+	}
+	resp, err := keeper.GetQuotas(ctx, path[0], path[1])
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+	if resp == nil {
+		return nil, sdk.ErrUnknownRequest(fmt.Sprintf("Repository not found: '%s/%s'", path[0],
+			path[1]))
+	}
+
+	bytes, err := encJson.Marshal(resp)
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+
+	return bytes, nil
+}
+
+func queryParams(ctx sdk.Context, keeper Keeper) ([]byte, sdk.Error) {
+	log.Debug().Msgf("Querying for module parameters")
+	bytes, err := encJson.Marshal(keeper.GetParams(ctx))
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+
+	return bytes, nil
+}
+
+func queryObjectPacks(ctx sdk.Context, path []string, keeper Keeper) ([]byte, sdk.Error) {
+	log.Debug().Msgf("Querying for packfiles containing object: %v", path)
+	if len(path) != 3 {
+		return nil, sdk.ErrUnknownRequest("Object packs query requires a repository and a hash")
+	}
+	h := plumbing.NewHash(path[2])
+	if h.IsZero() || h.String() != strings.ToLower(path[2]) {
+		return nil, sdk.ErrUnknownRequest(fmt.Sprintf("Invalid object hash: '%s'", path[2]))
+	}
+	resp, err := keeper.FindObject(ctx, path[0], path[1], h)
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+	if resp == nil {
+		return nil, sdk.ErrUnknownRequest(fmt.Sprintf("Repository not found: '%s/%s'", path[0],
+			path[1]))
+	}
 
-	log.Debug().Msgf("Returning advertised references: %+v", advRefs)
-	bytes, err := encJson.Marshal(advRefs)
+	bytes, err := encJson.Marshal(resp)
 	if err != nil {
 		return nil, sdk.ErrInternal(err.Error())
 	}
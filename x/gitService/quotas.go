@@ -0,0 +1,93 @@
+package gitService
+
+import (
+	"fmt"
+
+	"github.com/cosmos/cosmos-sdk/codec"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// Quotas limit the data of a repository, zero meaning no limit
+type Quotas struct {
+	// MaxRepoSize is the maximum number of bytes a repository may take up in the git store
+	MaxRepoSize int64 `json:"max_repo_size"`
+	// MaxPackSize is the maximum size in bytes of the packfile of a push
+	MaxPackSize int64 `json:"max_pack_size"`
+	// MaxRefs is the maximum number of references below refs/
+	MaxRefs int64 `json:"max_refs"`
+	// MaxObjectSize is the maximum size in bytes of a single object pushed
+	MaxObjectSize int64 `json:"max_object_size"`
+}
+
+// validate checks that quotas aren't negative
+func (q Quotas) validate() error {
+	if q.MaxRepoSize < 0 || q.MaxPackSize < 0 || q.MaxRefs < 0 || q.MaxObjectSize < 0 {
+		return fmt.Errorf("Quotas can't be negative")
+	}
+
+	return nil
+}
+
+// QuotasResponse is the response of the quotas query route
+type QuotasResponse struct {
+	URI string `json:"uri"`
+	// Quotas are the quotas applying to the repository
+	Quotas Quotas `json:"quotas"`
+	// Override says that the quotas are specific to the repository, rather than the module
+	// parameters
+	Override bool `json:"override"`
+	// RepoSize is the number of bytes the repository takes up in the git store
+	RepoSize int64 `json:"repo_size"`
+	// Refs is the number of references below refs/
+	Refs int64 `json:"refs"`
+}
+
+// quotasKey is the store key of the quotas overriding the module parameters for a repository
+func quotasKey(uri string) []byte {
+	return []byte(fmt.Sprintf("%s/quotas", uri))
+}
+
+// getRepoQuotas gets the quotas overriding the module parameters for a repository, or nil if
+// there are none
+func getRepoQuotas(store sdk.KVStore, cdc *codec.Codec, uri string) *Quotas {
+	bz := store.Get(quotasKey(uri))
+	if bz == nil {
+		return nil
+	}
+
+	var quotas Quotas
+	cdc.MustUnmarshalBinaryLengthPrefixed(bz, &quotas)
+	return &quotas
+}
+
+// setRepoQuotas sets the quotas overriding the module parameters for a repository, or deletes
+// them if nil
+func setRepoQuotas(store sdk.KVStore, cdc *codec.Codec, uri string, quotas *Quotas) {
+	if quotas == nil {
+		store.Delete(quotasKey(uri))
+		return
+	}
+
+	store.Set(quotasKey(uri), cdc.MustMarshalBinaryLengthPrefixed(*quotas))
+}
+
+// checkQuota checks that a value doesn't exceed a quota
+func checkQuota(codespace sdk.CodespaceType, what string, value int64, quota int64) sdk.Error {
+	if quota > 0 && value > quota {
+		return ErrQuotaExceeded(codespace, fmt.Sprintf("%s of %d exceeds the quota of %d", what,
+			value, quota))
+	}
+
+	return nil
+}
+
+// effectiveQuotas gets the quotas applying to a repository, which are its own if it has any and
+// otherwise those of the module parameters. It also says whether the repository has its own.
+func effectiveQuotas(store sdk.KVStore, cdc *codec.Codec, params Params, uri string) (Quotas,
+	bool) {
+	if quotas := getRepoQuotas(store, cdc, uri); quotas != nil {
+		return *quotas, true
+	}
+
+	return params.Quotas, false
+}
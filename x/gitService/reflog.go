@@ -0,0 +1,152 @@
+package gitService
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cosmos/cosmos-sdk/codec"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/tendermint/tendermint/crypto/tmhash"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// MaxReflogPageSize is the maximum number of entries returned by a single reflog query
+const MaxReflogPageSize = 100
+
+// ReflogEntry records a change of a reference
+type ReflogEntry struct {
+	// Index is the position of the entry in the reference's log, starting at 1
+	Index uint64 `json:"index"`
+	// Old is what the reference pointed to before, the zero hash if it got created
+	Old plumbing.Hash `json:"old"`
+	// New is what the reference points to after, the zero hash if it got deleted
+	New    plumbing.Hash  `json:"new"`
+	Author sdk.AccAddress `json:"author"`
+	// Height is the height of the block the change got applied in
+	Height int64     `json:"height"`
+	Time   time.Time `json:"time"`
+	// TxHash is the hash of the transaction that applied the change, as shown by Tendermint
+	TxHash string `json:"tx_hash"`
+}
+
+// ReflogQuery holds the parameters of the reflog query route, which get passed JSON encoded as
+// the query data. The zero value selects the newest page of entries.
+type ReflogQuery struct {
+	// Before is a cursor, as returned by a previous query, to continue listing entries with
+	// lower indexes
+	Before uint64 `json:"before,omitempty"`
+	// Limit is the maximum number of entries to return, at most MaxReflogPageSize
+	Limit int `json:"limit,omitempty"`
+}
+
+// ReflogResponse is the response of the reflog query route
+type ReflogResponse struct {
+	// Entries are the entries, the newest first
+	Entries []ReflogEntry `json:"entries"`
+	// Next is the cursor for the next page, 0 if there are no more entries
+	Next uint64 `json:"next,omitempty"`
+}
+
+// reflogPrefix is the key prefix of the log of a reference. The reference name is terminated
+// by a NUL, so that the logs of e.g. refs/heads/a and refs/heads/a/b don't overlap.
+func reflogPrefix(uri string, name plumbing.ReferenceName) []byte {
+	return []byte(fmt.Sprintf("%s/logs/%s\x00", uri, name))
+}
+
+// reflogKey is the key of an entry in the log of a reference. Indexes are zero padded, so that
+// keys are in index order.
+func reflogKey(uri string, name plumbing.ReferenceName, index uint64) []byte {
+	return append(reflogPrefix(uri, name), fmt.Sprintf("%020d", index)...)
+}
+
+// lastReflogIndex determines the index of the latest entry in the log of a reference, 0 if
+// there are none
+func lastReflogIndex(store sdk.KVStore, uri string, name plumbing.ReferenceName) (uint64,
+	error) {
+	prefix := reflogPrefix(uri, name)
+	iter := store.ReverseIterator(prefix, sdk.PrefixEndBytes(prefix))
+	defer iter.Close()
+	if !iter.Valid() {
+		return 0, nil
+	}
+
+	return strconv.ParseUint(string(iter.Key()[len(prefix):]), 10, 64)
+}
+
+// appendReflog appends an entry for a change of a reference, applied by the current
+// transaction, to its log
+func appendReflog(ctx sdk.Context, store sdk.KVStore, cdc *codec.Codec, uri string,
+	name plumbing.ReferenceName, old plumbing.Hash, new plumbing.Hash,
+	author sdk.AccAddress) error {
+	index, err := lastReflogIndex(store, uri, name)
+	if err != nil {
+		return err
+	}
+	index++
+
+	entry := ReflogEntry{
+		Index:  index,
+		Old:    old,
+		New:    new,
+		Author: author,
+		Height: ctx.BlockHeight(),
+		Time:   ctx.BlockHeader().Time,
+		TxHash: fmt.Sprintf("%X", tmhash.Sum(ctx.TxBytes())),
+	}
+	bz, err := cdc.MarshalBinaryLengthPrefixed(entry)
+	if err != nil {
+		return err
+	}
+
+	store.Set(reflogKey(uri, name, index), bz)
+	return nil
+}
+
+// getReflogEntry gets an entry in the log of a reference, or nil if it doesn't exist
+func getReflogEntry(store sdk.KVStore, cdc *codec.Codec, uri string,
+	name plumbing.ReferenceName, index uint64) (*ReflogEntry, error) {
+	bz := store.Get(reflogKey(uri, name, index))
+	if bz == nil {
+		return nil, nil
+	}
+
+	var entry ReflogEntry
+	if err := cdc.UnmarshalBinaryLengthPrefixed(bz, &entry); err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// listReflog lists the entries in the log of a reference matching a query, the newest first
+func listReflog(store sdk.KVStore, cdc *codec.Codec, uri string, name plumbing.ReferenceName,
+	query ReflogQuery) (*ReflogResponse, error) {
+	limit := query.Limit
+	if limit <= 0 || limit > MaxReflogPageSize {
+		limit = MaxReflogPageSize
+	}
+
+	prefix := reflogPrefix(uri, name)
+	end := sdk.PrefixEndBytes(prefix)
+	if query.Before > 0 {
+		end = reflogKey(uri, name, query.Before)
+	}
+	iter := store.ReverseIterator(prefix, end)
+	defer iter.Close()
+	resp := &ReflogResponse{}
+	for ; iter.Valid(); iter.Next() {
+		if len(resp.Entries) == limit {
+			resp.Next = resp.Entries[len(resp.Entries)-1].Index
+			break
+		}
+
+		var entry ReflogEntry
+		if err := cdc.UnmarshalBinaryLengthPrefixed(iter.Value(), &entry); err != nil {
+			return nil, err
+		}
+		resp.Entries = append(resp.Entries, entry)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,224 @@
+package gitService
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+)
+
+const (
+	// MaxRefsPageSize is the maximum number of references returned by a single listRefs or
+	// advertisedReferences query
+	MaxRefsPageSize = 1000
+	// MaxSymrefDepth is how many levels of symbolic references get followed, like in Git
+	MaxSymrefDepth = 5
+)
+
+var errPageFull = errors.New("Page is full")
+
+// RefsQuery holds the parameters of the listRefs and advertisedReferences query routes, which
+// get passed JSON encoded as the query data. The zero value selects the first page of all
+// references.
+type RefsQuery struct {
+	// RefPrefixes restricts references to those whose names start with any of the prefixes,
+	// like the ls-refs ref-prefix argument
+	RefPrefixes []string `json:"ref_prefixes,omitempty"`
+	// Peel says to include what annotated tags under refs/tags/ point to
+	Peel bool `json:"peel,omitempty"`
+	// Symrefs says to include the targets of symbolic references, rather than resolving them
+	Symrefs bool `json:"symrefs,omitempty"`
+	// Start is a cursor, as returned by a previous query, to continue listing after
+	Start string `json:"start,omitempty"`
+	// Limit is the maximum number of references to return, at most MaxRefsPageSize
+	Limit int `json:"limit,omitempty"`
+}
+
+// ListRefsResponse is the response of the listRefs query route
+type ListRefsResponse struct {
+	// Refs are references on the format of the Git remote helper list command
+	Refs []string `json:"refs"`
+	// Next is the cursor for the next page, empty if there are no more references
+	Next string `json:"next,omitempty"`
+}
+
+// AdvertisedReferencesResponse is the response of the advertisedReferences query route
+type AdvertisedReferencesResponse struct {
+	AdvRefs *packp.AdvRefs `json:"adv_refs"`
+	// Next is the cursor for the next page, empty if there are no more references
+	Next string `json:"next,omitempty"`
+}
+
+// MatchesRefPrefixes says whether a reference name matches any of a set of prefixes, an empty
+// set matching anything
+func MatchesRefPrefixes(name string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// refRanges determines the minimal set of key prefixes below refs/ to iterate over in order
+// to find references matching a set of prefixes, in store order
+func refRanges(prefixes []string) []string {
+	if MatchesRefPrefixes("refs/", prefixes) {
+		return []string{"refs/"}
+	}
+
+	var ranges []string
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(prefix, "refs/") {
+			ranges = append(ranges, prefix)
+		}
+	}
+	sort.Strings(ranges)
+
+	// Drop ranges contained in preceding ones
+	var minimal []string
+	for _, r := range ranges {
+		if len(minimal) > 0 && strings.HasPrefix(r, minimal[len(minimal)-1]) {
+			continue
+		}
+
+		minimal = append(minimal, r)
+	}
+
+	return minimal
+}
+
+// iterRefs calls fn for each reference of a repository matching a query, HEAD first and the
+// rest in name order, with the stored value. It returns the cursor for the next page, or an
+// empty string if there are no more references.
+func iterRefs(store sdk.KVStore, uri string, query RefsQuery,
+	fn func(name plumbing.ReferenceName, value string) error) (string, error) {
+	limit := query.Limit
+	if limit <= 0 || limit > MaxRefsPageSize {
+		limit = MaxRefsPageSize
+	}
+
+	count := 0
+	last := ""
+	if query.Start == "" && MatchesRefPrefixes("HEAD", query.RefPrefixes) {
+		if value := store.Get(headKey(uri)); value != nil {
+			if err := fn(plumbing.HEAD, string(value)); err != nil {
+				return "", err
+			}
+
+			count++
+			last = "HEAD"
+		}
+	}
+
+	var cursorKey []byte
+	if query.Start != "" && query.Start != "HEAD" {
+		cursorKey = []byte(fmt.Sprintf("%s/%s\x00", uri, query.Start))
+	}
+	for _, r := range refRanges(query.RefPrefixes) {
+		start := []byte(fmt.Sprintf("%s/%s", uri, r))
+		end := sdk.PrefixEndBytes(start)
+		if cursorKey != nil && bytes.Compare(cursorKey, start) > 0 {
+			start = cursorKey
+		}
+		if bytes.Compare(start, end) >= 0 {
+			continue
+		}
+
+		if err := func() error {
+			iter := store.Iterator(start, end)
+			defer iter.Close()
+			for ; iter.Valid(); iter.Next() {
+				if count == limit {
+					return errPageFull
+				}
+
+				name := string(iter.Key())[len(uri)+1:]
+				if err := fn(plumbing.ReferenceName(name), string(iter.Value())); err != nil {
+					return err
+				}
+
+				count++
+				last = name
+			}
+
+			return nil
+		}(); err != nil {
+			if err == errPageFull {
+				return last, nil
+			}
+
+			return "", err
+		}
+	}
+
+	return "", nil
+}
+
+// resolveRef resolves a reference's stored value to a hash, following symbolic references. If
+// the reference is unborn, the zero hash is returned.
+func resolveRef(store sdk.KVStore, uri string, name plumbing.ReferenceName,
+	value string) plumbing.Hash {
+	ref := plumbing.NewReferenceFromStrings(name.String(), value)
+	for i := 0; ref.Type() == plumbing.SymbolicReference && i < MaxSymrefDepth; i++ {
+		targetBytes := store.Get([]byte(fmt.Sprintf("%s/%s", uri, ref.Target())))
+		if targetBytes == nil {
+			return plumbing.ZeroHash
+		}
+
+		ref = plumbing.NewReferenceFromStrings(ref.Target().String(), string(targetBytes))
+	}
+	if ref.Type() != plumbing.HashReference {
+		return plumbing.ZeroHash
+	}
+
+	return ref.Hash()
+}
+
+// RefKey is the store key of a reference of a repository
+func RefKey(uri string, name plumbing.ReferenceName) []byte {
+	return []byte(fmt.Sprintf("%s/%s", uri, name))
+}
+
+// PeeledKey is the store key of the peeled value of a tag, i.e. what it ultimately points to
+func PeeledKey(uri string, name plumbing.ReferenceName) []byte {
+	return []byte(fmt.Sprintf("%s/peeled/%s", uri, name))
+}
+
+// refPeeler peels references to annotated tags, preferring the peeled values stored when the
+// tags got pushed and otherwise reading the tag objects
+type refPeeler struct {
+	store     sdk.KVStore
+	uri       string
+	objReader *objectReader
+}
+
+// peel returns what a reference under refs/tags/ ultimately points to, or false if it isn't an
+// annotated tag
+func (p *refPeeler) peel(name plumbing.ReferenceName, h plumbing.Hash) (plumbing.Hash, bool,
+	error) {
+	if !name.IsTag() {
+		return plumbing.ZeroHash, false, nil
+	}
+	if b := p.store.Get(PeeledKey(p.uri, name)); b != nil {
+		return plumbing.NewHash(string(b)), true, nil
+	}
+
+	if p.objReader == nil {
+		var err error
+		if p.objReader, err = newObjectReader(p.store, p.uri); err != nil {
+			return plumbing.ZeroHash, false, err
+		}
+	}
+	return p.objReader.peel(h)
+}
@@ -0,0 +1,327 @@
+package gitService
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/cosmos/cosmos-sdk/codec"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/idxfile"
+)
+
+// reHeadKey matches the store keys of the HEADs of repositories, of which every repository has
+// one
+var reHeadKey = regexp.MustCompile("^[^/]+/[^/]+/HEAD$")
+
+// GenesisRepository is the state of a repository in the genesis state
+type GenesisRepository struct {
+	Repository Repository `json:"repository"`
+	// Refs are the references, including HEAD, with what they point to as in Git's loose
+	// reference files, i.e. a hash or "ref: <name>"
+	Refs      []GenesisReference `json:"refs"`
+	Config    string             `json:"config"`
+	Packfiles []GenesisPackfile  `json:"packfiles"`
+	Reflogs   []GenesisReflog    `json:"reflogs"`
+	// Quotas are the repository's own quotas, nil if those of the module parameters apply
+	Quotas   *Quotas         `json:"quotas"`
+	Deposits []Deposit       `json:"deposits"`
+	Stats    RepositoryStats `json:"stats"`
+}
+
+// GenesisReference is a reference of a repository in the genesis state
+type GenesisReference struct {
+	Name   plumbing.ReferenceName `json:"name"`
+	Target string                 `json:"target"`
+}
+
+// GenesisPackfile is a packfile of a repository in the genesis state, with its index
+type GenesisPackfile struct {
+	Pack  []byte `json:"pack"`
+	Index []byte `json:"index"`
+}
+
+// GenesisReflog is the log of a reference of a repository in the genesis state
+type GenesisReflog struct {
+	Name    plumbing.ReferenceName `json:"name"`
+	Entries []ReflogEntry          `json:"entries"`
+}
+
+// reference converts a genesis reference to a reference
+func (r GenesisReference) reference() *plumbing.Reference {
+	return plumbing.NewReferenceFromStrings(r.Name.String(), r.Target)
+}
+
+// validate checks a genesis reference, which must be HEAD or below refs/, and point to a
+// hash or another such reference
+func (r GenesisReference) validate() error {
+	if r.Name != plumbing.HEAD && !isValidRefName(r.Name) {
+		return fmt.Errorf("Invalid reference name: '%s'", r.Name)
+	}
+
+	ref := r.reference()
+	switch ref.Type() {
+	case plumbing.SymbolicReference:
+		if ref.Target() != plumbing.HEAD && !isValidRefName(ref.Target()) {
+			return fmt.Errorf("Reference '%s' points to invalid name: '%s'", r.Name, r.Target)
+		}
+	case plumbing.HashReference:
+		if len(r.Target) != 40 || ref.Hash().IsZero() || ref.Hash().String() != r.Target {
+			return fmt.Errorf("Reference '%s' points to invalid hash: '%s'", r.Name, r.Target)
+		}
+	default:
+		return fmt.Errorf("Reference '%s' is invalid: '%s'", r.Name, r.Target)
+	}
+
+	return nil
+}
+
+// Checksum verifies the trailing checksum of a genesis packfile and that its index belongs to
+// it, returning the checksum, which names the packfile
+func (p GenesisPackfile) Checksum() (plumbing.Hash, error) {
+	// A packfile has a 12 byte header and a 20 byte trailing checksum
+	if len(p.Pack) < 32 || !bytes.HasPrefix(p.Pack, []byte("PACK")) {
+		return plumbing.ZeroHash, fmt.Errorf("Packfile is truncated or not a packfile")
+	}
+	var checksum plumbing.Hash
+	copy(checksum[:], p.Pack[len(p.Pack)-20:])
+	if plumbing.Hash(sha1.Sum(p.Pack[:len(p.Pack)-20])) != checksum {
+		return plumbing.ZeroHash, fmt.Errorf("Packfile %s doesn't match its checksum", checksum)
+	}
+
+	idx := idxfile.NewMemoryIndex()
+	if err := idxfile.NewDecoder(bytes.NewReader(p.Index)).Decode(idx); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("Index of packfile %s is invalid: %s", checksum,
+			err)
+	}
+	if plumbing.Hash(idx.PackfileChecksum) != checksum {
+		return plumbing.ZeroHash, fmt.Errorf("Index of packfile %s belongs to packfile %s",
+			checksum, plumbing.Hash(idx.PackfileChecksum))
+	}
+
+	return checksum, nil
+}
+
+// validate checks a repository in the genesis state, apart from whether the objects its
+// references point to are stored
+func (r GenesisRepository) validate() error {
+	uri := r.Repository.URI
+	if !reRepoURI.MatchString(uri) {
+		return fmt.Errorf("Invalid repository URI: '%s'", uri)
+	}
+	if !r.Repository.Visibility.Valid() {
+		return fmt.Errorf("Repository '%s' has invalid visibility '%s'", uri,
+			r.Repository.Visibility)
+	}
+
+	names := make(map[plumbing.ReferenceName]bool)
+	var refCount int64
+	for _, ref := range r.Refs {
+		if err := ref.validate(); err != nil {
+			return fmt.Errorf("Repository '%s': %s", uri, err)
+		}
+		if names[ref.Name] {
+			return fmt.Errorf("Repository '%s' has reference '%s' twice", uri, ref.Name)
+		}
+		names[ref.Name] = true
+		if ref.Name != plumbing.HEAD {
+			refCount++
+		}
+	}
+	if !names[plumbing.HEAD] {
+		return fmt.Errorf("Repository '%s' has no HEAD", uri)
+	}
+	if refCount != r.Stats.RefCount {
+		return fmt.Errorf("Repository '%s' has %d references, but its statistics count %d",
+			uri, refCount, r.Stats.RefCount)
+	}
+
+	for _, pack := range r.Packfiles {
+		if _, err := pack.Checksum(); err != nil {
+			return fmt.Errorf("Repository '%s': %s", uri, err)
+		}
+	}
+
+	for _, reflog := range r.Reflogs {
+		if !isValidRefName(reflog.Name) {
+			return fmt.Errorf("Repository '%s' has log of invalid reference name: '%s'", uri,
+				reflog.Name)
+		}
+		for i, entry := range reflog.Entries {
+			if entry.Index != uint64(i+1) {
+				return fmt.Errorf("Log of '%s' in repository '%s' has entry %d at position %d",
+					reflog.Name, uri, entry.Index, i+1)
+			}
+		}
+	}
+
+	if r.Quotas != nil {
+		if err := r.Quotas.validate(); err != nil {
+			return fmt.Errorf("Repository '%s': %s", uri, err)
+		}
+	}
+	for _, deposit := range r.Deposits {
+		if deposit.Depositor.Empty() || !deposit.Amount.IsValid() || deposit.Amount.IsZero() {
+			return fmt.Errorf("Repository '%s' has invalid deposit %s by '%s'", uri,
+				deposit.Amount, deposit.Depositor)
+		}
+	}
+
+	return nil
+}
+
+// exportRepository exports the state of a repository
+func (k Keeper) exportRepository(store sdk.KVStore, uri string) (*GenesisRepository, error) {
+	log.Debug().Msgf("Exporting repo '%s'", uri)
+	repo, err := k.getRepository(store, uri)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := getRepoStats(store, k.cdc, uri)
+	if err != nil {
+		return nil, err
+	}
+	gr := &GenesisRepository{
+		Repository: *repo,
+		Refs: []GenesisReference{
+			{Name: plumbing.HEAD, Target: string(store.Get(headKey(uri)))},
+		},
+		Config:   string(store.Get([]byte(fmt.Sprintf("%s/config", uri)))),
+		Quotas:   getRepoQuotas(store, k.cdc, uri),
+		Deposits: getDeposits(store, k.cdc, uri),
+		Stats:    stats,
+	}
+
+	refsPrefix := fmt.Sprintf("%s/", uri)
+	iter := sdk.KVStorePrefixIterator(store, []byte(refsPrefix+"refs/"))
+	for ; iter.Valid(); iter.Next() {
+		gr.Refs = append(gr.Refs, GenesisReference{
+			Name:   plumbing.ReferenceName(string(iter.Key())[len(refsPrefix):]),
+			Target: string(iter.Value()),
+		})
+	}
+	iter.Close()
+
+	packs, err := listPackfiles(store, uri)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range packs {
+		gr.Packfiles = append(gr.Packfiles, GenesisPackfile{
+			Pack:  store.Get([]byte(fmt.Sprintf("%s/objects/pack/pack-%s.pack", uri, h))),
+			Index: store.Get([]byte(fmt.Sprintf("%s/objects/pack/pack-%s.idx", uri, h))),
+		})
+	}
+
+	gr.Reflogs, err = exportReflogs(store, k.cdc, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return gr, nil
+}
+
+// exportReflogs exports the logs of the references of a repository, in reference name order
+func exportReflogs(store sdk.KVStore, cdc *codec.Codec, uri string) ([]GenesisReflog, error) {
+	prefix := fmt.Sprintf("%s/logs/", uri)
+	iter := sdk.KVStorePrefixIterator(store, []byte(prefix))
+	defer iter.Close()
+	var reflogs []GenesisReflog
+	for ; iter.Valid(); iter.Next() {
+		name := plumbing.ReferenceName(strings.SplitN(string(iter.Key())[len(prefix):], "\x00",
+			2)[0])
+		var entry ReflogEntry
+		if err := cdc.UnmarshalBinaryLengthPrefixed(iter.Value(), &entry); err != nil {
+			return nil, err
+		}
+		if len(reflogs) == 0 || reflogs[len(reflogs)-1].Name != name {
+			reflogs = append(reflogs, GenesisReflog{Name: name})
+		}
+		last := &reflogs[len(reflogs)-1]
+		last.Entries = append(last.Entries, entry)
+	}
+
+	return reflogs, nil
+}
+
+// importRepository imports the state of a repository, which must have been validated. The
+// peeled values of tags and the search index get derived from it, and it's checked that the
+// objects references point to are stored.
+func (k Keeper) importRepository(store sdk.KVStore, gr GenesisRepository) error {
+	uri := gr.Repository.URI
+	log.Debug().Msgf("Importing repo '%s'", uri)
+	if store.Has(headKey(uri)) {
+		return fmt.Errorf("Repository '%s' exists already", uri)
+	}
+
+	repo := gr.Repository
+	// The default branch is derived from HEAD
+	repo.DefaultBranch = ""
+	bz, err := k.cdc.MarshalBinaryBare(repo)
+	if err != nil {
+		return err
+	}
+	store.Set(metadataKey(uri), bz)
+	if gr.Config != "" {
+		store.Set([]byte(fmt.Sprintf("%s/config", uri)), []byte(gr.Config))
+	}
+	for _, pack := range gr.Packfiles {
+		checksum, err := pack.Checksum()
+		if err != nil {
+			return err
+		}
+		store.Set([]byte(fmt.Sprintf("%s/objects/pack/pack-%s.pack", uri, checksum)), pack.Pack)
+		store.Set([]byte(fmt.Sprintf("%s/objects/pack/pack-%s.idx", uri, checksum)), pack.Index)
+	}
+
+	objReader, err := newObjectReader(store, uri)
+	if err != nil {
+		return err
+	}
+	for _, ref := range gr.Refs {
+		writeReference(store, fmt.Sprintf("%s/%s", uri, ref.Name), ref.reference())
+		if ref.reference().Type() != plumbing.HashReference {
+			continue
+		}
+
+		h := ref.reference().Hash()
+		if err := checkObjectStored(objReader, h); err != nil {
+			return fmt.Errorf("Reference '%s' of repository '%s' is broken: %s", ref.Name, uri,
+				err)
+		}
+		if ref.Name.IsTag() {
+			peeled, ok, err := objReader.peel(h)
+			if err != nil {
+				return err
+			}
+			if ok {
+				store.Set(PeeledKey(uri, ref.Name), []byte(peeled.String()))
+			}
+		}
+	}
+
+	for _, reflog := range gr.Reflogs {
+		for _, entry := range reflog.Entries {
+			bz, err := k.cdc.MarshalBinaryLengthPrefixed(entry)
+			if err != nil {
+				return err
+			}
+			store.Set(reflogKey(uri, reflog.Name, entry.Index), bz)
+		}
+	}
+	setRepoQuotas(store, k.cdc, uri, gr.Quotas)
+	for _, deposit := range gr.Deposits {
+		store.Set(depositKey(uri, deposit.Depositor), k.cdc.MustMarshalBinaryLengthPrefixed(
+			deposit))
+	}
+	if err := setRepoStats(store, k.cdc, uri, gr.Stats); err != nil {
+		return err
+	}
+	indexRepoSearch(store, repo)
+
+	return nil
+}
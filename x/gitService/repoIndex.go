@@ -0,0 +1,154 @@
+package gitService
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/cosmos/cosmos-sdk/codec"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/rs/zerolog/log"
+)
+
+// MaxReposPageSize is the maximum number of repositories returned by a single repos query
+const MaxReposPageSize = 100
+
+// repoIndexPrefix is the key prefix of the repository index, which has an entry per repository
+// holding its statistics
+var repoIndexPrefix = []byte("/repos/")
+
+// RepositoryStats holds the statistics of a repository kept in the repository index
+type RepositoryStats struct {
+	// Size is the total size in bytes of the packfiles pushed to the repository
+	Size int64 `json:"size"`
+	// RefCount is the number of references below refs/
+	RefCount int64 `json:"ref_count"`
+	// LastPushHeight is the height of the block of the latest push, 0 if never pushed to
+	LastPushHeight int64 `json:"last_push_height"`
+	// Storage is the number of bytes the repository takes up in the git store, as accounted
+	// for storage deposits
+	Storage int64 `json:"storage"`
+}
+
+// RepositorySummary describes a repository in a repository listing
+type RepositorySummary struct {
+	URI   string         `json:"uri"`
+	Owner sdk.AccAddress `json:"owner,omitempty"`
+	// DefaultBranch is what HEAD points to
+	DefaultBranch string `json:"default_branch"`
+	RepositoryStats
+}
+
+// ReposQuery holds the parameters of the repos query route, which get passed JSON encoded as
+// the query data. The zero value selects the first page of all repositories.
+type ReposQuery struct {
+	// Owner restricts repositories to those under an owner, i.e. the first URI component
+	Owner string `json:"owner,omitempty"`
+	// Start is a cursor, as returned by a previous query, to continue listing after
+	Start string `json:"start,omitempty"`
+	// Limit is the maximum number of repositories to return, at most MaxReposPageSize
+	Limit int `json:"limit,omitempty"`
+}
+
+// ListReposResponse is the response of the repos query route
+type ListReposResponse struct {
+	Repositories []RepositorySummary `json:"repositories"`
+	// Next is the cursor for the next page, empty if there are no more repositories
+	Next string `json:"next,omitempty"`
+}
+
+// repoIndexKey is the key of a repository's entry in the repository index
+func repoIndexKey(uri string) []byte {
+	return append(append([]byte{}, repoIndexPrefix...), uri...)
+}
+
+// getRepoStats gets the statistics of a repository from the repository index. Repositories
+// created before the index got introduced aren't in it, so their statistics get computed from
+// the stored data instead.
+func getRepoStats(store sdk.KVStore, cdc *codec.Codec, uri string) (RepositoryStats, error) {
+	var stats RepositoryStats
+	if bz := store.Get(repoIndexKey(uri)); bz != nil {
+		err := cdc.UnmarshalBinaryLengthPrefixed(bz, &stats)
+		return stats, err
+	}
+
+	log.Debug().Msgf("Repo '%s' isn't indexed, computing its statistics", uri)
+	iter := sdk.KVStorePrefixIterator(store, []byte(fmt.Sprintf("%s/refs/", uri)))
+	for ; iter.Valid(); iter.Next() {
+		stats.RefCount++
+	}
+	iter.Close()
+
+	iter = sdk.KVStorePrefixIterator(store, []byte(fmt.Sprintf("%s/objects/pack/", uri)))
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		if strings.HasSuffix(string(iter.Key()), ".pack") {
+			stats.Size += int64(len(iter.Value()))
+		}
+	}
+	stats.Storage = repoStorage(store, uri)
+
+	return stats, nil
+}
+
+// setRepoStats sets the entry of a repository in the repository index. The entry is length
+// prefixed, since zero statistics would otherwise encode to an empty value, which can't be
+// stored.
+func setRepoStats(store sdk.KVStore, cdc *codec.Codec, uri string, stats RepositoryStats) error {
+	bz, err := cdc.MarshalBinaryLengthPrefixed(stats)
+	if err != nil {
+		return err
+	}
+
+	store.Set(repoIndexKey(uri), bz)
+	return nil
+}
+
+// iterRepos calls fn for each indexed repository matching a query, in URI order. It returns
+// the cursor for the next page, or an empty string if there are no more repositories.
+func iterRepos(store sdk.KVStore, cdc *codec.Codec, query ReposQuery,
+	fn func(uri string, stats RepositoryStats) error) (string, error) {
+	limit := query.Limit
+	if limit <= 0 || limit > MaxReposPageSize {
+		limit = MaxReposPageSize
+	}
+
+	start := repoIndexPrefix
+	if query.Owner != "" {
+		start = repoIndexKey(query.Owner + "/")
+	}
+	end := sdk.PrefixEndBytes(start)
+	if query.Start != "" {
+		if cursorKey := append(repoIndexKey(query.Start), 0); bytes.Compare(cursorKey,
+			start) > 0 {
+			start = cursorKey
+		}
+	}
+	if bytes.Compare(start, end) >= 0 {
+		return "", nil
+	}
+
+	iter := store.Iterator(start, end)
+	defer iter.Close()
+	count := 0
+	last := ""
+	for ; iter.Valid(); iter.Next() {
+		if count == limit {
+			return last, nil
+		}
+
+		uri := string(iter.Key()[len(repoIndexPrefix):])
+		var stats RepositoryStats
+		if err := cdc.UnmarshalBinaryLengthPrefixed(iter.Value(), &stats); err != nil {
+			return "", err
+		}
+		if err := fn(uri, stats); err != nil {
+			return "", err
+		}
+
+		count++
+		last = uri
+	}
+
+	return "", nil
+}
@@ -0,0 +1,128 @@
+package gitService
+
+import (
+	"fmt"
+
+	"github.com/cosmos/cosmos-sdk/codec"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+)
+
+const (
+	// statusOK is the status of an unpack or command that succeeded
+	statusOK = "ok"
+	// statusUnpackerError is the status of commands not applied since the packfile couldn't be
+	// stored, like in Git
+	statusUnpackerError = "unpacker error"
+	// statusAtomicFailure is the status of commands not applied since another command failed,
+	// like in Git, as a push is applied atomically
+	statusAtomicFailure = "atomic push failure"
+)
+
+// PushReport is the outcome of a MsgUpdateReferences, returned codec encoded as the data of
+// its result on success as well as failure. It corresponds to Git's report-status.
+type PushReport struct {
+	// UnpackStatus is "ok", or why the packfile couldn't be stored
+	UnpackStatus string `json:"unpack_status"`
+	// Commands are the statuses of the reference update commands, in message order
+	Commands []CommandReport `json:"commands"`
+	// Objects is the number of objects in the stored packfile
+	Objects int64 `json:"objects"`
+	// PackID is the checksum of the stored packfile, the zero hash if none got stored
+	PackID plumbing.Hash `json:"pack_id"`
+}
+
+// CommandReport is the status of a reference update command
+type CommandReport struct {
+	Name plumbing.ReferenceName `json:"name"`
+	// Status is "ok", or why the reference didn't get updated
+	Status string `json:"status"`
+	// Code is the code of the error that prevented the reference from getting updated, 0 if
+	// it got updated or another reference's update failed
+	Code sdk.CodeType `json:"code,omitempty"`
+}
+
+// commandError is the error of a reference update command that failed
+type commandError struct {
+	// index is the index of the command in the message
+	index int
+	name  plumbing.ReferenceName
+	code  sdk.CodeType
+	msg   string
+}
+
+func (e *commandError) Error() string {
+	return fmt.Sprintf("Failed to update reference '%s': %s", e.name, e.msg)
+}
+
+// newPushReport makes a report of all of a message's commands succeeding
+func newPushReport(msg MsgUpdateReferences) *PushReport {
+	report := &PushReport{
+		UnpackStatus: statusOK,
+		Commands:     make([]CommandReport, len(msg.Commands)),
+	}
+	for i, cmd := range msg.Commands {
+		report.Commands[i] = CommandReport{Name: cmd.Name, Status: statusOK}
+	}
+
+	return report
+}
+
+// failRemaining sets the status of the commands that haven't failed yet
+func (r *PushReport) failRemaining(status string, code sdk.CodeType) {
+	for i := range r.Commands {
+		if r.Commands[i].Status == statusOK {
+			r.Commands[i].Status = status
+			r.Commands[i].Code = code
+		}
+	}
+}
+
+// fail records that no commands got applied due to an error not concerning a particular one
+func (r *PushReport) fail(err sdk.Error) {
+	r.failRemaining(errorMessage(err), err.Code())
+}
+
+// failUnpack records that the packfile couldn't be stored, so no commands got applied
+func (r *PushReport) failUnpack(err sdk.Error) {
+	r.UnpackStatus = errorMessage(err)
+	r.failRemaining(statusUnpackerError, err.Code())
+}
+
+// failCommand records that a command failed, so no commands got applied
+func (r *PushReport) failCommand(err *commandError) {
+	r.Commands[err.index].Status = GitStatus(err.code)
+	r.Commands[err.index].Code = err.code
+	r.failRemaining(statusAtomicFailure, 0)
+}
+
+// ReportStatus converts the report to a Git report-status
+func (r *PushReport) ReportStatus() *packp.ReportStatus {
+	rs := packp.NewReportStatus()
+	rs.UnpackStatus = r.UnpackStatus
+	for _, cmd := range r.Commands {
+		rs.CommandStatuses = append(rs.CommandStatuses, &packp.CommandStatus{
+			ReferenceName: cmd.Name,
+			Status:        cmd.Status,
+		})
+	}
+
+	return rs
+}
+
+// encodePushReport encodes a report as result data. It is length prefixed, since baseapp
+// concatenates the data of the messages in a transaction.
+func encodePushReport(cdc *codec.Codec, report *PushReport) []byte {
+	return cdc.MustMarshalBinaryLengthPrefixed(*report)
+}
+
+// DecodePushReport decodes a report from the data of a MsgUpdateReferences result
+func DecodePushReport(cdc *codec.Codec, data []byte) (*PushReport, error) {
+	var report PushReport
+	if err := cdc.UnmarshalBinaryLengthPrefixed(data, &report); err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
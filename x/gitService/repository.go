@@ -0,0 +1,61 @@
+package gitService
+
+import (
+	"fmt"
+	"regexp"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// Visibility says who a repository is meant for. As all chain data is public, it's advisory,
+// for clients and indexers to respect.
+type Visibility string
+
+const (
+	// VisibilityPublic means the repository is listed and meant for anyone
+	VisibilityPublic Visibility = "public"
+	// VisibilityPrivate means the repository is meant for its owner only
+	VisibilityPrivate Visibility = "private"
+)
+
+// Valid says whether the visibility is a known one
+func (v Visibility) Valid() bool {
+	return v == VisibilityPublic || v == VisibilityPrivate
+}
+
+const (
+	maxDescriptionLength = 1024
+	maxLicenseLength     = 64
+	maxTopics            = 20
+)
+
+// reTopic matches valid topics, which are lowercase like on GitHub
+var reTopic = regexp.MustCompile("^[a-z0-9][a-z0-9-]{0,49}$")
+
+// Repository holds the metadata of a repository
+type Repository struct {
+	URI string `json:"uri"`
+	// Owner is the creator of the repository, unknown for repositories predating metadata
+	Owner sdk.AccAddress `json:"owner,omitempty"`
+	// DefaultBranch is what HEAD points to, it isn't stored but derived from HEAD
+	DefaultBranch plumbing.ReferenceName `json:"default_branch"`
+	Description   string                 `json:"description"`
+	Visibility    Visibility             `json:"visibility"`
+	// License is an SPDX license identifier, e.g. MIT
+	License string `json:"license"`
+	// CreatedAt is the height of the block the repository got created in
+	CreatedAt int64 `json:"created_at"`
+	// Topics classify the repository, for searching
+	Topics []string `json:"topics,omitempty"`
+}
+
+// metadataKey is the store key of a repository's metadata
+func metadataKey(uri string) []byte {
+	return []byte(fmt.Sprintf("%s/metadata", uri))
+}
+
+// headKey is the store key of a repository's HEAD, which exists for every repository
+func headKey(uri string) []byte {
+	return []byte(fmt.Sprintf("%s/HEAD", uri))
+}
@@ -0,0 +1,228 @@
+package gitService
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/cosmos/cosmos-sdk/codec"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// MaxSearchResults is the maximum number of repositories returned by a single search query
+	MaxSearchResults = 100
+	// maxSearchTermLength is the maximum number of bytes of the terms in the search index of
+	// repository names and descriptions, which bounds the size of the index to linear in the
+	// length of the text indexed
+	maxSearchTermLength = 16
+)
+
+var (
+	// searchTextPrefix is the key prefix of the search index of repository names and
+	// descriptions, which has an entry per suffix of each word, cut to maxSearchTermLength, so
+	// that a prefix scan finds the words containing a substring
+	searchTextPrefix = []byte("/search/text/")
+	// searchTopicPrefix is the key prefix of the search index of repository topics
+	searchTopicPrefix = []byte("/search/topic/")
+)
+
+// SearchQuery holds the parameters of the search query route, which get passed JSON encoded as
+// the query data. Repositories must match all of the given words and topics.
+type SearchQuery struct {
+	// Text is words to search for as substrings of repository owners, names and descriptions,
+	// case insensitively
+	Text string `json:"text,omitempty"`
+	// Topics are topics repositories must have
+	Topics []string `json:"topics,omitempty"`
+	// Limit is the maximum number of repositories to return, at most MaxSearchResults
+	Limit int `json:"limit,omitempty"`
+}
+
+// SearchResult is a repository found by a search
+type SearchResult struct {
+	Repository
+	// LastActivityHeight is the height of the block of the latest push, or creation if never
+	// pushed to
+	LastActivityHeight int64 `json:"last_activity_height"`
+}
+
+// searchWords splits text into lowercase words to index or search for
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("-_.", r)
+	})
+}
+
+// searchTerm cuts a word to at most maxSearchTermLength bytes, without splitting a character
+func searchTerm(word string) string {
+	if len(word) <= maxSearchTermLength {
+		return word
+	}
+
+	end := maxSearchTermLength
+	for end > 0 && !utf8.RuneStart(word[end]) {
+		end--
+	}
+	return word[:end]
+}
+
+// searchEntryKey is the key of a search index entry for a repository
+func searchEntryKey(prefix []byte, term string, uri string) []byte {
+	key := append(append([]byte{}, prefix...), term...)
+	key = append(key, 0)
+	return append(key, uri...)
+}
+
+// searchKeys determines the search index keys of a repository. The owner and name are
+// indexed as whole words.
+func searchKeys(repo Repository) [][]byte {
+	components := strings.SplitN(repo.URI, "/", 2)
+	words := append([]string{strings.ToLower(components[0]), strings.ToLower(components[1])},
+		searchWords(repo.Description)...)
+	suffixes := make(map[string]bool)
+	for _, word := range words {
+		for i := range word {
+			suffixes[searchTerm(word[i:])] = true
+		}
+	}
+
+	keys := make([][]byte, 0, len(suffixes)+len(repo.Topics))
+	for suffix := range suffixes {
+		keys = append(keys, searchEntryKey(searchTextPrefix, suffix, repo.URI))
+	}
+	for _, topic := range repo.Topics {
+		keys = append(keys, searchEntryKey(searchTopicPrefix, topic, repo.URI))
+	}
+
+	return keys
+}
+
+// isSearchIndexed says whether a repository is in the search index, which it is if its name is
+func isSearchIndexed(store sdk.KVStore, uri string) bool {
+	name := strings.SplitN(uri, "/", 2)[1]
+	return store.Has(searchEntryKey(searchTextPrefix, searchTerm(strings.ToLower(name)), uri))
+}
+
+// indexRepoSearch adds a repository to the search index
+func indexRepoSearch(store sdk.KVStore, repo Repository) {
+	log.Debug().Msgf("Adding repo '%s' to search index", repo.URI)
+	for _, key := range searchKeys(repo) {
+		store.Set(key, []byte{1})
+	}
+}
+
+// unindexRepoSearch removes a repository from the search index
+func unindexRepoSearch(store sdk.KVStore, repo Repository) {
+	log.Debug().Msgf("Removing repo '%s' from search index", repo.URI)
+	for _, key := range searchKeys(repo) {
+		store.Delete(key)
+	}
+}
+
+// searchIndex finds the URIs of repositories with an entry in a search index whose term
+// starts with a prefix
+func searchIndex(store sdk.KVStore, indexPrefix []byte, termPrefix string) map[string]bool {
+	uris := make(map[string]bool)
+	iter := sdk.KVStorePrefixIterator(store, append(append([]byte{}, indexPrefix...),
+		termPrefix...))
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		entry := iter.Key()[len(indexPrefix):]
+		if i := bytes.IndexByte(entry, 0); i >= 0 {
+			uris[string(entry[i+1:])] = true
+		}
+	}
+
+	return uris
+}
+
+// searchRepos finds the URIs of repositories matching a query through the search index
+func searchRepos(store sdk.KVStore, query SearchQuery) []string {
+	var matches map[string]bool
+	intersect := func(uris map[string]bool) {
+		if matches == nil {
+			matches = uris
+			return
+		}
+
+		for uri := range matches {
+			if !uris[uri] {
+				delete(matches, uri)
+			}
+		}
+	}
+	for _, word := range searchWords(query.Text) {
+		// Words longer than the indexed terms yield candidates, which matchesSearchText checks
+		intersect(searchIndex(store, searchTextPrefix, searchTerm(word)))
+	}
+	for _, topic := range query.Topics {
+		// Topics must match exactly
+		intersect(searchIndex(store, searchTopicPrefix, topic+"\x00"))
+	}
+
+	uris := make([]string, 0, len(matches))
+	for uri := range matches {
+		uris = append(uris, uri)
+	}
+	return uris
+}
+
+// matchesSearchText says whether a repository contains every word of a search text as a
+// substring of its owner, name or description
+func matchesSearchText(repo Repository, text string) bool {
+	components := strings.SplitN(repo.URI, "/", 2)
+	indexed := append([]string{strings.ToLower(components[0]), strings.ToLower(components[1])},
+		searchWords(repo.Description)...)
+	for _, word := range searchWords(text) {
+		found := false
+		for _, w := range indexed {
+			if strings.Contains(w, word) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// rankSearchResults orders search results by recent activity, the most recent first, and
+// limits their number
+func rankSearchResults(results []SearchResult, limit int) []SearchResult {
+	if limit <= 0 || limit > MaxSearchResults {
+		limit = MaxSearchResults
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].LastActivityHeight != results[j].LastActivityHeight {
+			return results[i].LastActivityHeight > results[j].LastActivityHeight
+		}
+
+		return results[i].URI < results[j].URI
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results
+}
+
+// lastActivityHeight determines the height of the latest activity in a repository
+func lastActivityHeight(store sdk.KVStore, cdc *codec.Codec, repo *Repository) (int64, error) {
+	stats, err := getRepoStats(store, cdc, repo.URI)
+	if err != nil {
+		return 0, err
+	}
+	if stats.LastPushHeight > 0 {
+		return stats.LastPushHeight, nil
+	}
+
+	return repo.CreatedAt, nil
+}
@@ -0,0 +1,225 @@
+package gitService
+
+import (
+	"fmt"
+
+	"github.com/cosmos/cosmos-sdk/codec"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/x/auth"
+	"github.com/rs/zerolog/log"
+)
+
+// depositPrefix is the key prefix of storage deposits, which have an entry per repository and
+// depositor
+var depositPrefix = []byte("/deposits/")
+
+// Deposit is the storage deposit an account has made for a repository
+type Deposit struct {
+	Depositor sdk.AccAddress `json:"depositor"`
+	Amount    sdk.Coins      `json:"amount"`
+}
+
+// StorageResponse is the response of the storage query route
+type StorageResponse struct {
+	URI string `json:"uri"`
+	// Storage is the number of bytes the repository takes up in the git store
+	Storage int64 `json:"storage"`
+	// Deposit is the total storage deposit made for the repository
+	Deposit  sdk.Coins `json:"deposit"`
+	Deposits []Deposit `json:"deposits"`
+}
+
+// storageStore wraps a store to measure the net number of bytes, of keys and values, written
+// to it
+type storageStore struct {
+	sdk.KVStore
+	delta int64
+}
+
+// Set implements sdk.KVStore
+func (s *storageStore) Set(key, value []byte) {
+	if old := s.KVStore.Get(key); old != nil {
+		s.delta -= int64(len(key) + len(old))
+	}
+	s.delta += int64(len(key) + len(value))
+	s.KVStore.Set(key, value)
+}
+
+// Delete implements sdk.KVStore
+func (s *storageStore) Delete(key []byte) {
+	if old := s.KVStore.Get(key); old != nil {
+		s.delta -= int64(len(key) + len(old))
+	}
+	s.KVStore.Delete(key)
+}
+
+// storageDeposit determines the deposit for storing a number of bytes, rounding up
+func storageDeposit(depositPerKiB sdk.Coins, size int64) sdk.Coins {
+	var deposit sdk.Coins
+	for _, coin := range depositPerKiB {
+		amount := coin.Amount.MulRaw(size).AddRaw(1023).DivRaw(1024)
+		if amount.Sign() > 0 {
+			deposit = append(deposit, sdk.NewCoin(coin.Denom, amount))
+		}
+	}
+
+	return deposit
+}
+
+// storageRefund determines the refund for freeing a number of bytes, rounding down so that
+// refunds don't exceed the deposits taken for the bytes
+func storageRefund(depositPerKiB sdk.Coins, size int64) sdk.Coins {
+	var refund sdk.Coins
+	for _, coin := range depositPerKiB {
+		amount := coin.Amount.MulRaw(size).DivRaw(1024)
+		if amount.Sign() > 0 {
+			refund = append(refund, sdk.NewCoin(coin.Denom, amount))
+		}
+	}
+
+	return refund
+}
+
+// depositPrefixFor is the key prefix of the storage deposits for a repository
+func depositPrefixFor(uri string) []byte {
+	return []byte(fmt.Sprintf("%s%s\x00", depositPrefix, uri))
+}
+
+// depositKey is the key of the storage deposit an account has made for a repository
+func depositKey(uri string, depositor sdk.AccAddress) []byte {
+	return append(depositPrefixFor(uri), depositor.Bytes()...)
+}
+
+// takeDeposit moves a storage deposit for a repository from an account's coins to the
+// account's deposit
+func takeDeposit(ctx sdk.Context, store sdk.KVStore, cdc *codec.Codec,
+	accountKeeper auth.AccountKeeper, uri string, depositor sdk.AccAddress,
+	amount sdk.Coins) sdk.Error {
+	log.Debug().Msgf("Taking storage deposit %s for repo '%s' from %s", amount, uri, depositor)
+	if err := subtractCoins(ctx, accountKeeper, depositor, amount,
+		"Storage deposit"); err != nil {
+		return err
+	}
+
+	key := depositKey(uri, depositor)
+	var deposit Deposit
+	if bz := store.Get(key); bz != nil {
+		cdc.MustUnmarshalBinaryLengthPrefixed(bz, &deposit)
+	}
+	deposit.Depositor = depositor
+	deposit.Amount = deposit.Amount.Plus(amount)
+	store.Set(key, cdc.MustMarshalBinaryLengthPrefixed(deposit))
+	return nil
+}
+
+// refundDeposit returns part of an account's storage deposit for a repository to the account,
+// at most the whole deposit, returning the amount refunded
+func refundDeposit(ctx sdk.Context, store sdk.KVStore, cdc *codec.Codec,
+	accountKeeper auth.AccountKeeper, uri string, depositor sdk.AccAddress,
+	amount sdk.Coins) (sdk.Coins, sdk.Error) {
+	key := depositKey(uri, depositor)
+	bz := store.Get(key)
+	if bz == nil {
+		return nil, nil
+	}
+	var deposit Deposit
+	cdc.MustUnmarshalBinaryLengthPrefixed(bz, &deposit)
+
+	var refund sdk.Coins
+	for _, coin := range amount {
+		refundAmount := sdk.MinInt(coin.Amount, deposit.Amount.AmountOf(coin.Denom))
+		if refundAmount.Sign() > 0 {
+			refund = append(refund, sdk.NewCoin(coin.Denom, refundAmount))
+		}
+	}
+	if refund.IsZero() {
+		return nil, nil
+	}
+
+	log.Debug().Msgf("Refunding storage deposit %s for repo '%s' to %s", refund, uri, depositor)
+	if err := addCoins(ctx, accountKeeper, depositor, refund); err != nil {
+		return nil, err
+	}
+	deposit.Amount = deposit.Amount.Minus(refund)
+	if deposit.Amount.IsZero() {
+		store.Delete(key)
+	} else {
+		store.Set(key, cdc.MustMarshalBinaryLengthPrefixed(deposit))
+	}
+	return refund, nil
+}
+
+// subtractCoins subtracts an amount, e.g. a storage deposit, from an account's coins
+func subtractCoins(ctx sdk.Context, accountKeeper auth.AccountKeeper, addr sdk.AccAddress,
+	amount sdk.Coins, what string) sdk.Error {
+	acc := accountKeeper.GetAccount(ctx, addr)
+	if acc == nil {
+		return sdk.ErrUnknownAddress(addr.String())
+	}
+	coins, hasNeg := acc.GetCoins().SafeMinus(amount)
+	if hasNeg {
+		return sdk.ErrInsufficientCoins(fmt.Sprintf("%s of %s exceeds available coins %s",
+			what, amount, acc.GetCoins()))
+	}
+	if err := acc.SetCoins(coins); err != nil {
+		return sdk.ErrInternal(err.Error())
+	}
+	accountKeeper.SetAccount(ctx, acc)
+	return nil
+}
+
+// addCoins adds an amount, e.g. a refunded storage deposit, to an account's coins
+func addCoins(ctx sdk.Context, accountKeeper auth.AccountKeeper, addr sdk.AccAddress,
+	amount sdk.Coins) sdk.Error {
+	acc := accountKeeper.GetAccount(ctx, addr)
+	if acc == nil {
+		acc = accountKeeper.NewAccountWithAddress(ctx, addr)
+	}
+	if err := acc.SetCoins(acc.GetCoins().Plus(amount)); err != nil {
+		return sdk.ErrInternal(err.Error())
+	}
+	accountKeeper.SetAccount(ctx, acc)
+	return nil
+}
+
+// getDeposits gets the storage deposits made for a repository
+func getDeposits(store sdk.KVStore, cdc *codec.Codec, uri string) []Deposit {
+	iter := sdk.KVStorePrefixIterator(store, depositPrefixFor(uri))
+	defer iter.Close()
+	var deposits []Deposit
+	for ; iter.Valid(); iter.Next() {
+		var deposit Deposit
+		cdc.MustUnmarshalBinaryLengthPrefixed(iter.Value(), &deposit)
+		deposits = append(deposits, deposit)
+	}
+
+	return deposits
+}
+
+// refundDeposits returns the storage deposits made for a repository to the depositors
+func refundDeposits(ctx sdk.Context, store sdk.KVStore, cdc *codec.Codec,
+	accountKeeper auth.AccountKeeper, uri string) sdk.Error {
+	for _, deposit := range getDeposits(store, cdc, uri) {
+		log.Debug().Msgf("Refunding storage deposit %s for repo '%s' to %s", deposit.Amount,
+			uri, deposit.Depositor)
+		if err := addCoins(ctx, accountKeeper, deposit.Depositor, deposit.Amount); err != nil {
+			return err
+		}
+		store.Delete(depositKey(uri, deposit.Depositor))
+	}
+
+	return nil
+}
+
+// repoStorage computes the number of bytes a repository takes up in the store, for
+// repositories that predate storage accounting
+func repoStorage(store sdk.KVStore, uri string) int64 {
+	iter := sdk.KVStorePrefixIterator(store, []byte(fmt.Sprintf("%s/", uri)))
+	defer iter.Close()
+	var size int64
+	for ; iter.Valid(); iter.Next() {
+		size += int64(len(iter.Key()) + len(iter.Value()))
+	}
+
+	return size
+}
@@ -0,0 +1,38 @@
+package gitService
+
+import (
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// Tag keys of the tags emitted for gitService messages, which the Tendermint transaction indexer
+// indexes, e.g. for finding pushes with `gitservicecli query txs --tags repo:<owner>/<name>`.
+// Baseapp adds an action tag with the message type.
+const (
+	// TagRepo is the tag key for the URI of the repository
+	TagRepo = "repo"
+	// TagAuthor is the tag key for the address of the message author
+	TagAuthor = "author"
+	// TagRef is the tag key for the name of a reference changed, one per reference
+	TagRef = "ref"
+	// TagOld is the tag key for what a reference pointed to before, following its TagRef tag
+	TagOld = "old"
+	// TagNew is the tag key for what a reference points to after, following its TagRef tag
+	TagNew = "new"
+)
+
+// repoTags makes the tags for a message concerning a repository
+func repoTags(uri string, author sdk.AccAddress) sdk.Tags {
+	return sdk.NewTags(
+		TagRepo, []byte(uri),
+		TagAuthor, []byte(author.String()),
+	)
+}
+
+// refTags makes the tags for a change of a reference
+func refTags(name string, old string, new string) sdk.Tags {
+	return sdk.NewTags(
+		TagRef, []byte(name),
+		TagOld, []byte(old),
+		TagNew, []byte(new),
+	)
+}